@@ -0,0 +1,370 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtin is a command implemented by the shell-less executor itself.
+type builtin func(bc *builtinContext, args []string) (int, error)
+
+// builtins are the commands which, in shell-less mode, run without starting a process.
+var builtins = map[string]builtin{
+	"cd":     builtinCd,
+	"echo":   builtinEcho,
+	"exit":   builtinExit,
+	"export": builtinExport,
+	"false":  builtinFalse,
+	"sleep":  builtinSleep,
+	"true":   builtinTrue,
+	"unset":  builtinUnset,
+}
+
+// builtinContext is the state a builtin runs with; dir and env point to the state of the
+// command group, or to a copy of it for builtins which are part of a pipeline, so that
+// their changes are discarded like in a subshell.
+type builtinContext struct {
+	cg             *CommandGroup
+	dir            *string
+	env            *[]string
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	// terminated is closed when the builtin must stop; for finally commands, only once killed
+	terminated <-chan struct{}
+}
+
+// scriptExit is returned by the exit builtin to stop the command line with the specified exit code.
+type scriptExit int
+
+func (e scriptExit) Error() string {
+	return fmt.Sprintf("exit %d", int(e))
+}
+
+// fail prints an error message of the builtin and returns exit code 1.
+func (bc *builtinContext) fail(format string, a ...interface{}) (int, error) {
+	if bc.stderr != nil {
+		fmt.Fprintf(bc.stderr, "coshell: "+format+"\n", a...)
+	}
+	return 1, nil
+}
+
+// runBuiltin runs a builtin with the specified standard streams; when subshell is true, changes
+// to directory and environment are discarded and exit only sets the exit code.
+func (cg *CommandGroup) runBuiltin(b builtin, args []string, stdio []interface{}, subshell bool) (int, error) {
+	bc := builtinContext{
+		cg:  cg,
+		dir: &cg.dir,
+		env: &cg.env,
+	}
+	if subshell {
+		dir, env := cg.dir, cg.env
+		bc.dir, bc.env = &dir, &env
+	}
+	bc.stdin, _ = stdio[0].(io.Reader)
+	bc.stdout, _ = stdio[1].(io.Writer)
+	bc.stderr, _ = stdio[2].(io.Writer)
+	cg.Lock()
+	if cg.finalizing {
+		bc.terminated = cg.killedC
+	} else {
+		bc.terminated = cg.terminatedC
+	}
+	cg.Unlock()
+
+	exitCode, err := b(&bc, args)
+	if e, ok := err.(scriptExit); ok && subshell {
+		return int(e), nil
+	}
+	return exitCode, err
+}
+
+// setEnv returns a copy of env with the variable set to value; env is never modified
+// in place, as its backing array can be shared with other command groups.
+func setEnv(env []string, name, value string) []string {
+	return append(unsetEnv(env, name), name+"="+value)
+}
+
+// unsetEnv returns a copy of env without any definition of the variable.
+func unsetEnv(env []string, name string) []string {
+	r := make([]string, 0, len(env)+1)
+	for _, v := range env {
+		if !strings.HasPrefix(v, name+"=") {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func isName(s string) bool {
+	return s != "" && nameLength(s) == len(s)
+}
+
+func builtinTrue(bc *builtinContext, args []string) (int, error) {
+	return 0, nil
+}
+
+func builtinFalse(bc *builtinContext, args []string) (int, error) {
+	return 1, nil
+}
+
+// builtinCd changes the directory of the following commands; without arguments
+// the directory is changed to $HOME, and with '-' to $OLDPWD.
+func builtinCd(bc *builtinContext, args []string) (int, error) {
+	if len(args) > 2 {
+		return bc.fail("cd: too many arguments")
+	}
+
+	var dir string
+	switch {
+	case len(args) == 1:
+		home, ok := lookupEnv(*bc.env, "HOME")
+		if !ok {
+			return bc.fail("cd: HOME not set")
+		}
+		dir = home
+	case args[1] == "-":
+		old, ok := lookupEnv(*bc.env, "OLDPWD")
+		if !ok {
+			return bc.fail("cd: OLDPWD not set")
+		}
+		dir = old
+	default:
+		dir = args[1]
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(*bc.dir, dir)
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return bc.fail("cd: %v", err)
+	}
+	if !fi.IsDir() {
+		return bc.fail("cd: %s: not a directory", dir)
+	}
+	if len(args) == 2 && args[1] == "-" && bc.stdout != nil {
+		fmt.Fprintln(bc.stdout, dir)
+	}
+
+	*bc.env = setEnv(setEnv(*bc.env, "OLDPWD", *bc.dir), "PWD", dir)
+	*bc.dir = dir
+	return 0, nil
+}
+
+// builtinExport sets the variables of the following commands; without arguments
+// all variables are printed.
+func builtinExport(bc *builtinContext, args []string) (int, error) {
+	if len(args) == 1 {
+		if bc.stdout == nil {
+			return bc.fail("export: standard output is not open for writing")
+		}
+		vars := append([]string(nil), *bc.env...)
+		sort.Strings(vars)
+		for _, v := range vars {
+			eq := strings.IndexByte(v, '=')
+			if eq == -1 {
+				continue
+			}
+			fmt.Fprintf(bc.stdout, "export %s=%s\n", v[:eq], Quote([]string{v[eq+1:]}))
+		}
+		return 0, nil
+	}
+
+	exitCode := 0
+	for _, arg := range args[1:] {
+		name, value := arg, ""
+		eq := strings.IndexByte(arg, '=')
+		if eq != -1 {
+			name, value = arg[:eq], arg[eq+1:]
+		}
+		if !isName(name) {
+			exitCode, _ = bc.fail("export: '%s': not a valid identifier", arg)
+			continue
+		}
+		// all variables are exported, thus names without a value are left as-is
+		if eq != -1 {
+			*bc.env = setEnv(*bc.env, name, value)
+		}
+	}
+	return exitCode, nil
+}
+
+// builtinUnset removes the variables from the environment of the following commands.
+func builtinUnset(bc *builtinContext, args []string) (int, error) {
+	exitCode := 0
+	for _, name := range args[1:] {
+		if name == "-v" {
+			continue
+		}
+		if !isName(name) {
+			exitCode, _ = bc.fail("unset: '%s': not a valid identifier", name)
+			continue
+		}
+		*bc.env = unsetEnv(*bc.env, name)
+	}
+	return exitCode, nil
+}
+
+// builtinSleep pauses for the sum of the specified durations, either numbers of seconds
+// with an optional s, m, h or d suffix, or Go durations like "1m30s"; the pause is
+// interrupted if the command group is terminated, except in finally commands.
+func builtinSleep(bc *builtinContext, args []string) (int, error) {
+	if len(args) == 1 {
+		return bc.fail("sleep: missing operand")
+	}
+	var total time.Duration
+	for _, arg := range args[1:] {
+		d, err := parseSleep(arg)
+		if err != nil {
+			return bc.fail("sleep: invalid time interval '%s'", arg)
+		}
+		total += d
+	}
+
+	timer := time.NewTimer(total)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0, nil
+	case <-bc.terminated:
+		return -1, errTerminated
+	}
+}
+
+func parseSleep(s string) (time.Duration, error) {
+	unit := time.Second
+	number := s
+	if n := len(s); n > 1 {
+		if u, ok := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}[s[n-1]]; ok {
+			unit, number = u, s[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return time.ParseDuration(s)
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("negative interval %s", s)
+	}
+	return time.Duration(f * float64(unit)), nil
+}
+
+// builtinExit stops the command line with the specified exit code, or with the exit code
+// of the last pipeline if none is specified.
+func builtinExit(bc *builtinContext, args []string) (int, error) {
+	switch len(args) {
+	case 1:
+		return 0, scriptExit(bc.cg.lastExitCode)
+	case 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			bc.fail("exit: %s: numeric argument required", args[1])
+			return 0, scriptExit(2)
+		}
+		return 0, scriptExit(n & 0xff)
+	}
+	return bc.fail("exit: too many arguments")
+}
+
+// builtinEcho writes its arguments separated by spaces and followed by a newline;
+// like bash, -n omits the newline and -e enables backslash escapes.
+func builtinEcho(bc *builtinContext, args []string) (int, error) {
+	newline, escapes := true, false
+	args = args[1:]
+	for len(args) != 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+
+	var b strings.Builder
+	for i, arg := range args {
+		if i != 0 {
+			b.WriteByte(' ')
+		}
+		if escapes {
+			decoded, stop := echoUnescape(arg)
+			b.WriteString(decoded)
+			if stop {
+				newline = false
+				break
+			}
+			continue
+		}
+		b.WriteString(arg)
+	}
+	if newline {
+		b.WriteByte('\n')
+	}
+
+	if bc.stdout == nil {
+		return bc.fail("echo: standard output is not open for writing")
+	}
+	_, err := io.WriteString(bc.stdout, b.String())
+	if err != nil {
+		return bc.fail("echo: %v", err)
+	}
+	return 0, nil
+}
+
+// echoUnescape decodes the escapes of echo -e, which are the same as those of $'...' strings
+// except for octal escapes starting with \0; true is returned if output stops because of \c.
+func echoUnescape(arg string) (string, bool) {
+	var b strings.Builder
+	stop := false
+	for i := 0; i < len(arg); i++ {
+		if arg[i] != '\\' || i+1 == len(arg) {
+			b.WriteByte(arg[i])
+			continue
+		}
+		switch arg[i+1] {
+		case 'c':
+			stop = true
+		case '0':
+			n, l := parseDigits(arg[i+2:], 8, 3)
+			fmt.Fprintf(&b, `\x%02x`, n&0xff)
+			i += 1 + l
+			continue
+		default:
+			b.WriteString(arg[i : i+2])
+			i++
+			continue
+		}
+		break
+	}
+	decoded, _, _ := decodeANSIC(b.String(), false)
+	return decoded, stop
+}
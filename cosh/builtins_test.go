@@ -0,0 +1,123 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBuiltins(t *testing.T) {
+	var buf bytes.Buffer
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.Expand = true
+
+	lines := []string{
+		"cd /tmp",
+		"pwd",
+		"export FOO=bar BAZ",
+		"sh -c 'echo $FOO'",
+		"unset FOO",
+		"echo ${FOO-unset}",
+		"echo -n a; echo -e 'b\\tc\\c' ignored; echo d",
+		"echo x | cat",
+		"cd / | true; pwd",
+		"true && exit 0; echo skipped",
+		"sleep 0.01 10ms",
+		"false || exit",
+		"echo never",
+	}
+	cp := NewCommandPool(&cfg)
+	err := cp.Add(len(lines), lines...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 1 {
+		t.Errorf("expected exit code 1 but got %d", exitCode)
+	}
+
+	expected := "/tmp\nbar\nunset\nab\tcd\nx\n/tmp\n"
+	if buf.String() != expected {
+		t.Fatalf("expected output %q but got %q", expected, buf.String())
+	}
+}
+
+func TestSleepTerminated(t *testing.T) {
+	cfg := DefaultCommandPoolConfig
+	cfg.Halt = true
+
+	cp := NewCommandPool(&cfg)
+	err := cp.Add(1, "sleep 10", "sleep 0.1; false")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("sleep was not interrupted")
+	}
+}
+
+func TestParseSleep(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"1":     time.Second,
+		"0.5":   500 * time.Millisecond,
+		"2m":    2 * time.Minute,
+		"1d":    24 * time.Hour,
+		"1m30s": 90 * time.Second,
+	} {
+		d, err := parseSleep(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if d != expected {
+			t.Errorf("%q: expected %v but got %v", s, expected, d)
+		}
+	}
+
+	for _, s := range []string{"", "x", "-1", "1x"} {
+		_, err := parseSleep(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
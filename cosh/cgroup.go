@@ -0,0 +1,153 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CgroupLimits are the limits of the cgroup of a command group; zero values are not set.
+type CgroupLimits struct {
+	// MemoryMax is the maximum memory usage in bytes (memory.max)
+	MemoryMax int64
+	// CPUMax is the maximum CPU bandwidth as a number of CPUs, e.g. 1.5 (cpu.max)
+	CPUMax float64
+	// PidsMax is the maximum number of processes (pids.max)
+	PidsMax int64
+}
+
+// cpuMaxPeriod is the period of cpu.max, in microseconds.
+const cpuMaxPeriod = 100000
+
+// merge returns the limits with the non-zero limits of override replacing them.
+func (l CgroupLimits) merge(override CgroupLimits) CgroupLimits {
+	if override.MemoryMax != 0 {
+		l.MemoryMax = override.MemoryMax
+	}
+	if override.CPUMax != 0 {
+		l.CPUMax = override.CPUMax
+	}
+	if override.PidsMax != 0 {
+		l.PidsMax = override.PidsMax
+	}
+	return l
+}
+
+// ParseCPUMax parses a CPU bandwidth as a positive number of CPUs, e.g. "0.5".
+func ParseCPUMax(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 || n*cpuMaxPeriod < 1 {
+		return 0, fmt.Errorf("invalid number of CPUs %q", s)
+	}
+	return n, nil
+}
+
+// ParsePidsMax parses a positive maximum number of processes.
+func ParsePidsMax(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid number of processes %q", s)
+	}
+	return n, nil
+}
+
+// Result is the outcome of a command group.
+type Result struct {
+	// Name is the name of the job, if any, and Index the position of the command group in the command pool
+	Name  string
+	Index int
+	// Completed is false for command groups which did not complete, e.g. because of ShutdownTimeout
+	Completed bool
+	ExitCode  int
+	// PeakMemory is the peak memory usage of the processes of the command group in bytes, if known;
+	// it requires Cgroups and a kernel reporting memory.peak (Linux 5.19 or later)
+	PeakMemory int64
+}
+
+// Results returns the outcome of each command group, once Join returned.
+func (cp *CommandPool) Results() []Result {
+	groups := cp.groupList()
+	results := make([]Result, len(groups))
+	for i, cg := range groups {
+		results[i] = Result{
+			Name:       cg.name,
+			Index:      i,
+			PeakMemory: cg.peakMemory,
+		}
+		select {
+		case <-cg.done:
+			results[i].Completed, results[i].ExitCode = true, cg.exitCode
+		default:
+		}
+	}
+	return results
+}
+
+// createCgroups creates the cgroup of each command group which needs one, within the cgroup
+// of the command pool.
+func (cp *CommandPool) createCgroups() error {
+	for _, cg := range cp.groups {
+		err := cp.createCgroup(cg)
+		if err != nil {
+			cp.removeCgroups()
+			return err
+		}
+	}
+	return nil
+}
+
+// createCgroup creates the cgroup of the command group, if it needs one.
+func (cp *CommandPool) createCgroup(cg *CommandGroup) error {
+	if !cp.Cgroups && cg.cgroupLimits == (CgroupLimits{}) {
+		return nil
+	}
+	if cp.cgroups == nil {
+		var err error
+		cp.cgroups, err = newCgroupRoot(cp.CgroupParent)
+		if err != nil {
+			return err
+		}
+	}
+	c, err := cp.cgroups.create(fmt.Sprintf("job-%d", cg.index), cg.cgroupLimits)
+	if err != nil {
+		return err
+	}
+	cg.cgroup = c
+	return nil
+}
+
+// removeCgroups records the peak memory usage of command groups and removes their cgroups,
+// once all their processes exited.
+func (cp *CommandPool) removeCgroups() {
+	if cp.cgroups == nil {
+		return
+	}
+	for _, cg := range cp.groupList() {
+		if cg.cgroup == nil {
+			continue
+		}
+		cg.peakMemory = cg.cgroup.peakMemory()
+		cg.cgroup.remove()
+		cg.cgroup = nil
+	}
+	cp.cgroups.remove()
+	cp.cgroups = nil
+}
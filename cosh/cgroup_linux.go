@@ -0,0 +1,296 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cgroupControllers are the controllers enabled for the cgroups of command groups, if available.
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// cgroupRoot is the cgroup of a command pool, containing the cgroups of its command groups.
+type cgroupRoot struct {
+	dir string
+	// controllers are the controllers enabled for the cgroups of command groups
+	controllers []string
+	// moved is true when coshell moved itself in a leaf of dir, so that controllers could be
+	// enabled in its former cgroup; enabled are the controllers it enabled there
+	moved   bool
+	enabled []string
+}
+
+// cgroup is the cgroup of a command group; fd is used to start processes within it.
+type cgroup struct {
+	dir string
+	fd  int
+}
+
+// newCgroupRoot creates the cgroup of a command pool within the specified parent, or within the
+// cgroup of coshell if empty, which must be delegated to the user running coshell.
+func newCgroupRoot(parent string) (*cgroupRoot, error) {
+	own, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	if parent == "" {
+		parent = own
+	}
+	data, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2: %v", parent, err)
+	}
+	var controllers []string
+	for _, c := range cgroupControllers {
+		for _, available := range strings.Fields(string(data)) {
+			if c == available {
+				controllers = append(controllers, c)
+			}
+		}
+	}
+
+	r := &cgroupRoot{
+		dir:         filepath.Join(parent, fmt.Sprintf("coshell-%d", os.Getpid())),
+		controllers: controllers,
+	}
+	err = os.Mkdir(r.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	_, err = enableControllers(parent, controllers)
+	if errors.Is(err, syscall.EBUSY) && parent == own {
+		// a cgroup cannot have both processes and controllers enabled for its children;
+		// coshell is moved back when removing the cgroup
+		leaf := filepath.Join(r.dir, "coshell")
+		err = os.Mkdir(leaf, 0755)
+		if err == nil {
+			err = moveToCgroup(leaf)
+		}
+		if err == nil {
+			r.moved = true
+			r.enabled, err = enableControllers(parent, controllers)
+		}
+	}
+	if err == nil {
+		_, err = enableControllers(r.dir, controllers)
+	}
+	if err != nil {
+		r.remove()
+		return nil, fmt.Errorf("cannot enable cgroup controllers in %s: %v", parent, err)
+	}
+	return r, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 of coshell.
+func ownCgroup() (string, error) {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	path := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "0::") {
+			path = strings.TrimPrefix(scanner.Text(), "0::")
+		}
+	}
+	if path == "" {
+		return "", errors.New("cgroup v2 is not available")
+	}
+
+	// the cgroup2 mount point, e.g. /sys/fs/cgroup or /sys/fs/cgroup/unified with hybrid hierarchies
+	data, err = ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// ID parent-ID major:minor root mount-point options [optional fields] - type source options
+		fields := strings.Fields(scanner.Text())
+		for i, f := range fields {
+			if f == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" && len(fields) > 4 {
+				return filepath.Join(fields[4], path), nil
+			}
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// enableControllers enables the controllers for the children of a cgroup, unless already enabled;
+// the controllers which were enabled are returned.
+func enableControllers(dir string, controllers []string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return nil, err
+	}
+	enabled := strings.Fields(string(data))
+	var add []string
+	for _, c := range controllers {
+		found := false
+		for _, e := range enabled {
+			found = found || e == c
+		}
+		if !found {
+			add = append(add, c)
+		}
+	}
+	if len(add) == 0 {
+		return nil, nil
+	}
+	err = writeSubtreeControl(dir, "+", add)
+	if err != nil {
+		return nil, err
+	}
+	return add, nil
+}
+
+// writeSubtreeControl enables, with op "+", or disables, with op "-", the controllers for the
+// children of a cgroup.
+func writeSubtreeControl(dir, op string, controllers []string) error {
+	if len(controllers) == 0 {
+		return nil
+	}
+	changes := make([]string, len(controllers))
+	for i, c := range controllers {
+		changes[i] = op + c
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(changes, " ")), 0644)
+}
+
+// moveToCgroup moves coshell, with all its threads, to the cgroup in dir.
+func moveToCgroup(dir string) error {
+	return ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// create creates the cgroup of a command group with the specified limits.
+func (r *cgroupRoot) create(name string, limits CgroupLimits) (*cgroup, error) {
+	c := &cgroup{dir: filepath.Join(r.dir, name), fd: -1}
+	err := os.Mkdir(c.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// controller, file and value of each limit
+	var files [][3]string
+	if limits.MemoryMax != 0 {
+		files = append(files, [3]string{"memory", "memory.max", strconv.FormatInt(limits.MemoryMax, 10)})
+	}
+	if limits.CPUMax != 0 {
+		files = append(files, [3]string{"cpu", "cpu.max", fmt.Sprintf("%d %d", int64(limits.CPUMax*cpuMaxPeriod), cpuMaxPeriod)})
+	}
+	if limits.PidsMax != 0 {
+		files = append(files, [3]string{"pids", "pids.max", strconv.FormatInt(limits.PidsMax, 10)})
+	}
+	for _, f := range files {
+		if !r.hasController(f[0]) {
+			c.remove()
+			return nil, fmt.Errorf("cannot set %s: the %s controller is not available in %s", f[1], f[0], filepath.Dir(r.dir))
+		}
+		err = ioutil.WriteFile(filepath.Join(c.dir, f[1]), []byte(f[2]), 0644)
+		if err != nil {
+			c.remove()
+			return nil, fmt.Errorf("cannot set %s of cgroup %s: %v", f[1], c.dir, err)
+		}
+	}
+
+	c.fd, err = syscall.Open(c.dir, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		c.remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *cgroupRoot) hasController(controller string) bool {
+	for _, c := range r.controllers {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the cgroup of the command pool, once the cgroups of command groups were removed;
+// if coshell moved itself within it, the controllers it enabled are disabled and it is moved back
+// to its former cgroup first.
+func (r *cgroupRoot) remove() {
+	if r.moved {
+		parent := filepath.Dir(r.dir)
+		// controllers can be disabled only once no child cgroup enables them for its own children
+		writeSubtreeControl(r.dir, "-", r.controllers)
+		writeSubtreeControl(parent, "-", r.enabled)
+		err := moveToCgroup(parent)
+		if err != nil {
+			// other controllers are enabled, the cgroup cannot be removed
+			return
+		}
+		r.moved = false
+		syscall.Rmdir(filepath.Join(r.dir, "coshell"))
+	}
+	syscall.Rmdir(r.dir)
+}
+
+// setSysProcAttr makes processes start within the cgroup.
+func (c *cgroup) setSysProcAttr(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD, attr.CgroupFD = true, c.fd
+}
+
+// kill kills all the processes of the cgroup, including descendants left behind.
+func (c *cgroup) kill() error {
+	return ioutil.WriteFile(filepath.Join(c.dir, "cgroup.kill"), []byte("1"), 0644)
+}
+
+// populated returns true if any process is still running within the cgroup.
+func (c *cgroup) populated() bool {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, "cgroup.events"))
+	return err == nil && bytes.Contains(data, []byte("populated 1"))
+}
+
+// peakMemory returns the peak memory usage of the cgroup in bytes, or 0 if not available.
+func (c *cgroup) peakMemory() int64 {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, "memory.peak"))
+	if err != nil {
+		return 0
+	}
+	return parseInt(string(data))
+}
+
+// release closes the descriptor used to start processes, once no process is started anymore.
+func (c *cgroup) release() {
+	if c.fd != -1 {
+		syscall.Close(c.fd)
+		c.fd = -1
+	}
+}
+
+// remove removes the cgroup; it fails if processes are still running within it.
+func (c *cgroup) remove() error {
+	c.release()
+	return syscall.Rmdir(c.dir)
+}
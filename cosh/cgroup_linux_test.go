@@ -0,0 +1,153 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCgroups(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.Cgroups = true
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(
+		JobSpec{Name: "a", Commands: []string{"sh -c 'grep ^0:: /proc/self/cgroup'"}},
+		JobSpec{Name: "b", Commands: []string{"sh -c 'grep ^0:: /proc/self/cgroup; exit 3'"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Skipf("cgroups are not available: %v", err)
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 3 {
+		t.Errorf("expected exit code 3 but got %d", exitCode)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "/job-0") || !strings.HasSuffix(lines[1], "/job-1") {
+		t.Errorf("unexpected cgroups %q", lines)
+	}
+}
+
+func TestCgroupKill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-cgroup")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Stdout, cfg.Stderr = ioutil.Discard, ioutil.Discard
+	cfg.Cgroups = true
+	cfg.Masters = []string{"master"}
+	cfg.ShutdownTimeout = 5 * time.Second
+
+	cp := NewCommandPool(&cfg)
+	err = cp.AddJobs(
+		JobSpec{Name: "master", Commands: []string{"sleep 0.2"}},
+		// a descendant in its own session escapes process groups, but not the cgroup
+		JobSpec{Name: "escape", Commands: []string{fmt.Sprintf("sh -c 'setsid sleep 100 >/dev/null 2>&1 & echo $! > %s; exec sleep 100'", pidFile)}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Skipf("cgroups are not available: %v", err)
+	}
+	_, err = cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// the descendant might be a zombie until reaped by its parent
+	stat, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if len(stat) != 0 && !strings.Contains(string(stat), ") Z ") {
+		t.Errorf("process %d was not killed: %s", pid, stat)
+	}
+
+	results := cp.Results()
+	if !results[0].Completed || results[0].ExitCode != 0 || results[0].Name != "master" {
+		t.Errorf("unexpected result %+v", results[0])
+	}
+	if !results[1].Completed || results[1].ExitCode == 0 {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+}
+
+func TestCgroupLimits(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.CgroupLimits = CgroupLimits{PidsMax: 10, CPUMax: 0.5}
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(
+		JobSpec{Commands: []string{"sh -c 'cd /sys/fs/cgroup/$(sed -n s/^0:://p /proc/self/cgroup) 2>/dev/null || cd /sys/fs/cgroup/unified/$(sed -n s/^0:://p /proc/self/cgroup); cat pids.max cpu.max memory.max'"},
+			CgroupLimits: CgroupLimits{MemoryMax: 64 << 20}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Skipf("cgroup controllers are not available: %v", err)
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 but got %d", exitCode)
+	}
+	expected := "10\n50000 100000\n67108864\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
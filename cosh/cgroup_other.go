@@ -0,0 +1,67 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errCgroupsUnsupported is returned when cgroups are used on platforms other than Linux.
+var errCgroupsUnsupported = errors.New("cgroups are only supported on Linux")
+
+type cgroupRoot struct{}
+
+type cgroup struct {
+	dir string
+}
+
+func newCgroupRoot(parent string) (*cgroupRoot, error) {
+	return nil, errCgroupsUnsupported
+}
+
+func (r *cgroupRoot) create(name string, limits CgroupLimits) (*cgroup, error) {
+	return nil, errCgroupsUnsupported
+}
+
+func (r *cgroupRoot) remove() {}
+
+func (c *cgroup) setSysProcAttr(attr *syscall.SysProcAttr) {}
+
+func (c *cgroup) release() {}
+
+func (c *cgroup) kill() error {
+	return errCgroupsUnsupported
+}
+
+func (c *cgroup) populated() bool {
+	return false
+}
+
+func (c *cgroup) peakMemory() int64 {
+	return 0
+}
+
+func (c *cgroup) remove() error {
+	return errCgroupsUnsupported
+}
@@ -0,0 +1,49 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "testing"
+
+func TestCgroupLimitsMerge(t *testing.T) {
+	limits := CgroupLimits{MemoryMax: 1 << 30, PidsMax: 100}.merge(CgroupLimits{PidsMax: 10, CPUMax: 2})
+	expected := CgroupLimits{MemoryMax: 1 << 30, CPUMax: 2, PidsMax: 10}
+	if limits != expected {
+		t.Errorf("expected %+v but got %+v", expected, limits)
+	}
+}
+
+func TestParseCPUMax(t *testing.T) {
+	for s, expected := range map[string]float64{"1": 1, "0.5": 0.5, "2.25": 2.25} {
+		n, err := ParseCPUMax(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if n != expected {
+			t.Errorf("%q: expected %v but got %v", s, expected, n)
+		}
+	}
+	for _, s := range []string{"", "0", "-1", "x", "0.000001"} {
+		_, err := ParseCPUMax(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
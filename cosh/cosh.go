@@ -23,9 +23,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // ErrEmptyCommandLine is returned when an empty command line is specified.
@@ -35,23 +39,107 @@ type event struct {
 	index    int
 	err      error
 	exitCode int
+	// ready is true for events of command groups which became ready, rather than completed
+	ready bool
 }
 
 // CommandPoolConfig is the configuration for a command pool.
 type CommandPoolConfig struct {
 	Deinterlace bool
 	Halt        bool
-	MasterID    int
-	ShellArgs   []string
-	Stdout      io.Writer
-	Stderr      io.Writer
+	// MasterID is the index of a master command group, or -1; see Masters.
+	MasterID int
+	// Masters are the names, or indexes, of the master command groups: once they exit according to
+	// MasterPolicy, all other command groups are terminated and the exit code selected by MasterExit
+	// is used.
+	Masters []string
+	// MasterPolicy specifies whether the command pool is terminated when any or all masters exit.
+	MasterPolicy MasterPolicy
+	// MasterExit specifies which exit code of the masters is used.
+	MasterExit MasterExit
+	// Restart controls restarts of command groups, unless specified by their job.
+	Restart RestartConfig
+	// TermSignal is sent to the processes of command groups when they are terminated, because
+	// of Halt, masters, timeouts or Terminate, before killing them if they did not exit within
+	// KillAfter, or 10 seconds if zero; if zero, processes are killed right away.
+	TermSignal syscall.Signal
+	KillAfter  time.Duration
+	ShellArgs  []string
+	Stdout     io.Writer
+	Stderr     io.Writer
+	// Stdin is the standard input mode for command groups.
+	Stdin StdinMode
+	// StdinPath is the file attached as standard input with StdinFile mode.
+	StdinPath string
+	// Expand enables expansion of parameters and tildes in shell-less mode, using
+	// the environment of each command; expanded values are never split in further words.
+	Expand bool
+	// NoUnset makes expansion of unset parameters an error.
+	NoUnset bool
+	// Glob enables brace and pathname expansion in shell-less mode, relative
+	// to the working directory of each command.
+	Glob bool
+	// ProcessGroups starts each process in its own process group, so that its descendants
+	// are signalled and waited for as well; processes cannot read from the terminal. Descendants
+	// whose parent exited are only waited for if the program is their subreaper, see SetSubreaper.
+	// Process groups are not supported on Windows.
+	ProcessGroups bool
+	// ShutdownTimeout, if not zero, is the maximum time to wait for processes to exit once
+	// command groups are terminated, or after they completed for descendants left behind;
+	// processes still running are then killed and reported.
+	ShutdownTimeout time.Duration
+	// MaxLoad, if not zero, queues command groups while the load average is at least MaxLoad.
+	MaxLoad float64
+	// MinMemFree, if not zero, queues command groups while less than MinMemFree bytes of
+	// memory are available.
+	MinMemFree int64
+	// StartDelay is the minimum time between the start of any two command groups.
+	StartDelay time.Duration
+	// Nice, if not zero, is the niceness of processes, and IONice, if its class is not IONone,
+	// their I/O priority, unless specified by their job; both are set before commands run, by running
+	// the program itself as a wrapper of each command, which requires calling RunWrapperIfRequested
+	// first thing in main.
+	Nice   int
+	IONice IOPriority
+	// Rlimits are the resource limits of processes, set before commands run like priorities;
+	// commands whose limits cannot be set do not run and their exit code is 126.
+	Rlimits []Rlimit
+	// Cgroups places each command group in its own cgroup v2, created within CgroupParent, or the
+	// cgroup of coshell if empty, which must be delegated; all the processes of command groups are
+	// then killed with cgroup.kill, and their peak memory usage is returned by Results. Command groups
+	// with CgroupLimits, also from their job, are always placed in their own cgroup.
+	Cgroups      bool
+	CgroupParent string
+	CgroupLimits CgroupLimits
 }
 
 // CommandPool is a command pool with associated configuration and state.
 type CommandPool struct {
+	// groupsMu protects groups and outputs, which grow while running with AddPipeStream
+	groupsMu        sync.Mutex
 	groups          []*CommandGroup
 	outputs         []*SortedOutput
 	completedGroups chan event
+	// joined is closed when Join returns, after which events are not sent anymore
+	joined chan struct{}
+	// stream is the source of the command groups added while running, if any
+	stream *pipeStream
+	// masters are the indexes of the master command groups, resolved when starting
+	masters map[int]bool
+	// sem limits the number of command groups running concurrently
+	sem *semaphore
+	// startMu serializes command groups waiting to start, which last started at lastStart
+	startMu   sync.Mutex
+	lastStart time.Time
+	// deadline is closed when the shutdown deadline passes, once started
+	deadline     chan struct{}
+	shutdownOnce sync.Once
+	// stopping is closed once command groups start being terminated
+	stopping chan struct{}
+	// deps are the indexes of the dependencies of each command group, resolved when starting
+	deps [][]int
+	// cgroups is the cgroup containing those of command groups, if any
+	cgroups *cgroupRoot
 
 	CommandPoolConfig
 }
@@ -71,6 +159,10 @@ func NewCommandPool(cfg *CommandPoolConfig) *CommandPool {
 	}
 	return &CommandPool{
 		CommandPoolConfig: *cfg,
+		deadline:          make(chan struct{}),
+		stopping:          make(chan struct{}),
+		joined:            make(chan struct{}),
+		sem:               newSemaphore(0),
 	}
 }
 
@@ -86,19 +178,12 @@ func (cp *CommandPool) Add(sequenceLength int, commandLines ...string) error {
 
 	// prepare command groups to be executed sequentially
 	l := len(commandLines) / sequenceLength
-	cp.groups = make([]*CommandGroup, l)
-	if cp.Deinterlace {
-		cp.outputs = make([]*SortedOutput, l)
-	}
 	for i := 0; i < l; i++ {
-		var stdout, stderr io.Writer
-		if cp.Deinterlace {
-			cp.outputs[i] = NewSortedOutput(cp.Stdout, cp.Stderr)
-			stdout, stderr = cp.outputs[i].stdout, cp.outputs[i].stderr
-		} else {
-			stdout, stderr = cp.Stdout, cp.Stderr
+		stdin, err := cp.stdinFor(len(cp.groups))
+		if err != nil {
+			return err
 		}
-		cp.groups[i], err = cp.NewCommandGroup(cwd, env, stdout, stderr, commandLines[i*sequenceLength:(i+1)*sequenceLength])
+		err = cp.addGroup(cwd, env, stdin, commandLines[i*sequenceLength:(i+1)*sequenceLength])
 		if err != nil {
 			return err
 		}
@@ -107,94 +192,289 @@ func (cp *CommandPool) Add(sequenceLength int, commandLines ...string) error {
 	return nil
 }
 
-// Start will start all command groups concurrently.
+// AddGroup will add the specified command lines as a single group, which will run sequentially
+// and require that the previous command is successful.
+func (cp *CommandPool) AddGroup(commandLines ...string) error {
+	return cp.AddGroups(InputGroup{CommandLines: commandLines})
+}
+
+// AddGroups will add a command group for each of the specified groups, which can have
+// different lengths.
+func (cp *CommandPool) AddGroups(groups ...InputGroup) error {
+	jobs := make([]JobSpec, len(groups))
+	for i, g := range groups {
+		jobs[i] = g.JobSpec()
+	}
+	return cp.AddJobs(jobs...)
+}
+
+// addGroup appends a new command group for the specified command lines to the pool.
+func (cp *CommandPool) addGroup(cwd string, env []string, stdin stdinSource, commandLines []string) error {
+	var stdout, stderr io.Writer
+	if cp.Deinterlace {
+		output := NewSortedOutput(cp.Stdout, cp.Stderr)
+		cp.outputs = append(cp.outputs, output)
+		stdout, stderr = output.stdout, output.stderr
+	} else {
+		stdout, stderr = cp.Stdout, cp.Stderr
+	}
+	cg, err := cp.NewCommandGroup(cwd, env, stdout, stderr, commandLines)
+	if err != nil {
+		return err
+	}
+	cg.stdin = stdin
+	cg.index = len(cp.groups)
+	cg.restart = cp.Restart
+	cg.stopSignal, cg.stopGrace = cp.TermSignal, cp.KillAfter
+	cg.nice, cg.ionice = cp.Nice, cp.IONice
+	cg.rlimits = cp.Rlimits
+	cg.cgroupLimits = cp.CgroupLimits
+	cp.groups = append(cp.groups, cg)
+
+	return nil
+}
+
+// Start will start all command groups concurrently; command groups with dependencies start
+// only once all of them completed successfully.
 func (cp *CommandPool) Start(jobs int) error {
-	if jobs == 0 {
-		// set to maximum possible
-		jobs = len(cp.groups)
+	deps, err := cp.dependencies()
+	if err != nil {
+		return err
+	}
+	cp.deps = deps
+	cp.masters, err = cp.resolveMasters()
+	if err != nil {
+		return err
+	}
+	if cp.ProcessGroups {
+		err = checkProcessGroups()
+		if err != nil {
+			return err
+		}
 	}
-	res := make(chan struct{}, jobs)
-	for i := 0; i < jobs; i++ {
-		res <- struct{}{}
+	if cp.MaxLoad != 0 || cp.MinMemFree != 0 {
+		// fail early if not supported
+		_, err = cp.resourcesAvailable()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cp.createCgroups()
+	if err != nil {
+		return err
 	}
 
-	cp.completedGroups = make(chan event, len(cp.groups))
+	cp.SetJobs(jobs)
+
+	// room for both ready and completion events
+	cp.completedGroups = make(chan event, 2*len(cp.groups))
 
 	// run all groups concurrently
-	for i := 0; i < len(cp.groups); i++ {
-		go func(i int) {
-			<-res
-
-			exitCode, err := cp.groups[i].Run()
-			cp.completedGroups <- event{
-				index:    i,
-				err:      err,
-				exitCode: exitCode,
+	for i, cg := range cp.groups {
+		go cp.run(cg, cp.groups, deps[i], false)
+	}
+	if cp.stream != nil {
+		go cp.feed()
+	}
+
+	return nil
+}
+
+// run runs the command group once its dependencies, among groups, completed successfully and
+// a job slot is available, unless acquired is true because it was already taken.
+func (cp *CommandPool) run(cg *CommandGroup, groups []*CommandGroup, deps []int, acquired bool) {
+	if cg.ready != nil {
+		go func() {
+			select {
+			case <-cg.ready:
+				cp.notify(event{index: cg.index, ready: true})
+			case <-cg.done:
 			}
+		}()
+	}
 
-			res <- struct{}{}
-		}(i)
+	var (
+		exitCode int
+		err      error
+	)
+	// dependencies are waited for without holding a job slot
+	exitCode = cg.waitDependencies(groups, deps)
+	if exitCode == 0 {
+		started := acquired || cp.sem.acquire(cg.terminatedC)
+		if started && !cp.waitStart(cg.terminatedC) {
+			cp.sem.release()
+			started = false
+		}
+		// when terminated before starting, only finally commands run; no job slot is
+		// taken, as it could be held by groups stopped later
+		exitCode, err = cg.Run()
+		if started {
+			cp.sem.release()
+		}
+	} else if acquired {
+		cp.sem.release()
+	}
+	// input is not read anymore, e.g. a chunk of a stream, which must not stay in memory,
+	// and no process is started anymore
+	cg.stdin = nil
+	if cg.cgroup != nil {
+		cg.cgroup.release()
 	}
+	// read by dependent groups once done is closed
+	cg.exitCode = exitCode
+	close(cg.done)
+
+	cp.notify(event{
+		index:    cg.index,
+		err:      err,
+		exitCode: exitCode,
+	})
+}
 
-	return nil
+// notify sends an event to Join, unless it already returned.
+func (cp *CommandPool) notify(ev event) {
+	select {
+	case cp.completedGroups <- ev:
+	case <-cp.joined:
+	}
+}
+
+// groupList returns the command groups added so far.
+func (cp *CommandPool) groupList() []*CommandGroup {
+	cp.groupsMu.Lock()
+	defer cp.groupsMu.Unlock()
+	return cp.groups
 }
 
 // Join waits for all command groups to complete execution and return the (unsigned) sum of each individual exit code.
+// Once command groups are terminated, Join also waits for all their processes to exit, up to ShutdownTimeout.
 func (cp *CommandPool) Join() (int, error) {
-	l := len(cp.groups)
+	defer close(cp.joined)
 
 	displayedSoFar := 0
 	var outputErrors []error
 	var exitCode uint
 	var exitSelected bool
+	// exit codes of the masters, in order of exit
+	var masterExitCodes []int
+	completed := map[int]bool{}
+	deadlinePassed := false
+	// command groups are added until the whole stream was read, or they are terminated
+	feeding := cp.stream != nil
+	stopping := cp.stopping
+
+wait:
+	for feeding || len(completed) < len(cp.groupList()) {
+		var ev event
+		select {
+		case ev = <-cp.completedGroups:
+		case <-stopping:
+			// no command group is added anymore
+			feeding, stopping = false, nil
+			continue
+		case <-cp.deadline:
+			for i, cg := range cp.groupList() {
+				if !completed[i] {
+					fmt.Fprintf(cp.Stderr, "coshell: %s did not complete within %v\n", cg.displayName(), cp.ShutdownTimeout)
+				}
+			}
+			deadlinePassed = true
+			break wait
+		}
 
-	for count := 0; count < l; count++ {
-		ev := <-cp.completedGroups
+		if ev.index == -1 {
+			// the stream was read
+			feeding = false
+			if ev.err != nil {
+				cp.shutdown(-1)
+				cp.waitProcesses()
+				cp.removeCgroups()
+				return -1, fmt.Errorf("reading input: %w", ev.err)
+			}
+			continue
+		}
+		cg := cp.groupList()[ev.index]
+		if ev.ready {
+			fmt.Fprintf(cp.Stderr, "coshell: %s is ready\n", cg.displayName())
+			continue
+		}
+		completed[ev.index] = true
 
 		if cp.Deinterlace {
-			// print deinterlaced output on the go
-			if displayedSoFar == ev.index {
-				if err := cp.outputs[ev.index].ReplayOutputs(); err != nil {
+			// print deinterlaced output on the go, also of the following groups which already
+			// completed, so that it is not kept in memory
+			for completed[displayedSoFar] {
+				if err := cp.output(displayedSoFar).ReplayOutputs(); err != nil {
 					outputErrors = append(outputErrors, err)
 				}
-				displayedSoFar++
 
 				// will not be accessed anymore
-				cp.outputs[ev.index] = nil
+				cp.groupsMu.Lock()
+				cp.outputs[displayedSoFar] = nil
+				cp.groupsMu.Unlock()
+				displayedSoFar++
 			}
 		}
 
 		// an unexpected error during wait and exit code processing
 		if ev.err != nil {
-			cp.terminateAll(ev.index)
-			//NOTE: not waiting for processes to terminate
-			return -1, ev.err
+			cp.shutdown(ev.index)
+			cp.waitProcesses()
+			cp.removeCgroups()
+			return -1, fmt.Errorf("%s: %w", cg.displayName(), ev.err)
 		}
 
 		if exitSelected {
 			continue
 		}
 
-		// some process terminated, spool outputs and use its exit code
-		if (cp.Halt && ev.exitCode != 0) ||
-			// master process exited, terminate all and use its exit code
-			(cp.MasterID != -1 && cp.MasterID == ev.index) {
+		isMaster := cp.masters[ev.index]
+		if isMaster {
+			masterExitCodes = append(masterExitCodes, ev.exitCode)
+		}
 
-			cp.terminateAll(ev.index)
+		// some process terminated, spool outputs and use its exit code
+		if cp.Halt && ev.exitCode != 0 {
+			cp.shutdown(ev.index)
 
 			exitCode = uint(ev.exitCode)
 			exitSelected = true
 			continue
 		}
 
+		if isMaster {
+			// with the all policy, wait for the other masters
+			if cp.MasterPolicy == MasterAny || len(masterExitCodes) == len(cp.masters) {
+				// master process exited, terminate all and use the selected exit code of masters
+				cp.shutdown(ev.index)
+
+				exitCode = uint(cp.MasterExit.selectExitCode(masterExitCodes))
+				exitSelected = true
+			}
+			continue
+		}
+
 		// regular processes
 		exitCode += uint(ev.exitCode)
 	}
 
+	// descendants left behind by completed command groups are terminated as well
+	if !deadlinePassed && len(cp.survivors()) != 0 {
+		cp.startShutdown()
+		cp.terminateAll(-1)
+	}
+	cp.waitProcesses()
+	cp.removeCgroups()
+
 	// print remaining unsorted outputs
 	if cp.Deinterlace {
-		for i := displayedSoFar; i < l; i++ {
-			if err := cp.outputs[i].ReplayOutputs(); err != nil {
+		for i := displayedSoFar; i < len(cp.groupList()); i++ {
+			if !completed[i] {
+				// still written to by processes which did not exit
+				continue
+			}
+			if err := cp.output(i).ReplayOutputs(); err != nil {
 				outputErrors = append(outputErrors, err)
 			}
 		}
@@ -207,37 +487,158 @@ func (cp *CommandPool) Join() (int, error) {
 	return int(exitCode), nil
 }
 
-func (cp *CommandPool) prepareCommand(cmdLine string) (*exec.Cmd, error) {
+// output returns the sorted output of the command group at the specified index.
+func (cp *CommandPool) output(index int) *SortedOutput {
+	cp.groupsMu.Lock()
+	defer cp.groupsMu.Unlock()
+	return cp.outputs[index]
+}
+
+// resolveMasters returns the indexes of the master command groups.
+func (cp *CommandPool) resolveMasters() (map[int]bool, error) {
+	masters := map[int]bool{}
+	// like before Masters, an index beyond the last command group never matches
+	if cp.MasterID >= 0 && cp.MasterID < len(cp.groups) {
+		masters[cp.MasterID] = true
+	}
+	for _, m := range cp.Masters {
+		i := cp.groupIndex(m)
+		if i == -1 {
+			return nil, fmt.Errorf("unknown master %q", m)
+		}
+		masters[i] = true
+	}
+	return masters, nil
+}
+
+// groupIndex returns the index of the command group with the specified name or, if there is
+// none, with the specified index; -1 is returned if there is no such command group.
+func (cp *CommandPool) groupIndex(nameOrIndex string) int {
+	for i, cg := range cp.groups {
+		if cg.name == nameOrIndex {
+			return i
+		}
+	}
+	i, err := strconv.Atoi(nameOrIndex)
+	if err != nil || i < 0 || i >= len(cp.groups) {
+		return -1
+	}
+	return i
+}
+
+func (cp *CommandPool) prepareCommand(cmdLine string) (*command, error) {
 	// using a shell prefix, append the whole command line
 	if len(cp.ShellArgs) != 0 {
-		return exec.Command(cp.ShellArgs[0], append(cp.ShellArgs[1:], cmdLine)...), nil
+		return &command{
+			line: cmdLine,
+			args: append(cp.ShellArgs[:len(cp.ShellArgs):len(cp.ShellArgs)], cmdLine),
+		}, nil
 	}
 
-	args, err := Split(cmdLine)
+	s, err := parseScript(cmdLine, cp.Expand)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(args) == 0 {
-		return nil, ErrEmptyCommandLine
+	return &command{
+		line:   cmdLine,
+		script: s,
+	}, nil
+}
+
+// Quoter returns the quoter for command lines of the configured shell; command lines
+// are split with Split when no shell is used.
+func (cp *CommandPool) Quoter() Quoter {
+	if len(cp.ShellArgs) != 0 {
+		return QuoteForShell(cp.ShellArgs[0])
+	}
+	return Join
+}
+
+// DryRun writes the command groups which would be run, one per line, prefixed by their name if any,
+// with commands in sequence joined by the operator of the group mode and followed by a comment
+// with finally commands, if any.
+// Each command is written as the quoted invocation of the shell, or as-is when no shell is used.
+func (cp *CommandPool) DryRun(w io.Writer) error {
+	quote := cp.Quoter()
+	format := func(commands []*command, sep string) string {
+		lines := make([]string, len(commands))
+		for i, cmd := range commands {
+			if cmd.script != nil {
+				lines[i] = cmd.line
+			} else {
+				lines[i] = quote(cmd.args)
+			}
+		}
+		return strings.Join(lines, sep)
 	}
+	for _, cg := range cp.groups {
+		line := format(cg.commands, " "+cg.mode.String()+" ")
+		if cg.name != "" {
+			line = cg.name + ": " + line
+		}
+		if len(cg.finally) != 0 {
+			line += " # finally: " + format(cg.finally, "; ")
+		}
+		if cg.ready != nil {
+			line += " # ready: " + cg.readyProbe.String()
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return exec.Command(args[0], args[1:]...), nil
+// Jobs returns the maximum number of command groups running concurrently.
+func (cp *CommandPool) Jobs() int {
+	return cp.sem.getLimit()
 }
 
-func (cp *CommandPool) terminateAll(exceptIndex int) {
-	var wg sync.WaitGroup
-	for i, cg := range cp.groups {
-		if i == exceptIndex {
-			continue
+// SetJobs changes the maximum number of command groups running concurrently, also while they
+// run; 0 means unlimited concurrency. When it is lowered, command groups already running are
+// not affected, but no other one starts until enough of them completed.
+func (cp *CommandPool) SetJobs(jobs int) {
+	if jobs == 0 {
+		// set to maximum possible
+		jobs = len(cp.groupList())
+		if cp.stream != nil {
+			jobs = math.MaxInt32
 		}
+	}
+	cp.sem.setLimit(jobs)
+}
 
-		wg.Add(1)
-		go func(cg *CommandGroup) {
-			cg.terminate()
-			wg.Done()
-		}(cg)
+// Terminate stops all command groups, in the same order as when a master exits; it can be called
+// while waiting for them with Join, e.g. when coshell itself is signalled.
+func (cp *CommandPool) Terminate() {
+	cp.startShutdown()
+	cp.terminateAll(-1)
+}
+
+// Kill kills the processes of all command groups right away, including those of finally commands.
+func (cp *CommandPool) Kill() {
+	for _, cg := range cp.groupList() {
+		cg.kill()
+		if cg.probeGroup != nil {
+			cg.probeGroup.kill()
+		}
 	}
+}
 
-	wg.Wait()
+// terminateAll stops all command groups except the specified one, in the stages returned by
+// shutdownStages; each stage is stopped concurrently and waited for before the next one.
+func (cp *CommandPool) terminateAll(exceptIndex int) {
+	for _, stage := range cp.shutdownStages(exceptIndex) {
+		var wg sync.WaitGroup
+		for _, cg := range stage {
+			wg.Add(1)
+			go func(cg *CommandGroup) {
+				cg.stop()
+				wg.Done()
+			}(cg)
+		}
+		wg.Wait()
+	}
 }
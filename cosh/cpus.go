@@ -0,0 +1,60 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseJobs parses a number of jobs: either an absolute number, where 0 means unlimited
+// concurrency, a percentage of the available CPUs like "200%", or a number of CPUs to add
+// to or subtract from the available ones, like "+2" or "-1". Relative numbers of jobs are
+// at least 1.
+func ParseJobs(s string) (int, error) {
+	invalid := fmt.Errorf("invalid number of jobs: %q", s)
+	switch {
+	case strings.HasSuffix(s, "%"):
+		percent, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || percent <= 0 {
+			return 0, invalid
+		}
+		return atLeastOne(int(float64(AvailableCPUs()) * percent / 100)), nil
+	case strings.HasPrefix(s, "+"), strings.HasPrefix(s, "-"):
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, invalid
+		}
+		return atLeastOne(AvailableCPUs() + n), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, invalid
+	}
+	return n, nil
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,126 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupFS is where cgroup filesystems are mounted.
+const cgroupFS = "/sys/fs/cgroup"
+
+// AvailableCPUs returns the number of CPUs available to the process, according to its CPU
+// affinity and to the CPU quota of its cgroup, if any.
+func AvailableCPUs() int {
+	// the affinity mask of the process is used by the runtime
+	n := runtime.NumCPU()
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return n
+	}
+	if quota, ok := cgroupCPUQuota(cgroupFS, data); ok && quota < n {
+		return atLeastOne(quota)
+	}
+	return n
+}
+
+// cgroupCPUQuota returns the CPU quota, rounded up to a number of CPUs, of the cgroup described
+// by the contents of /proc/self/cgroup and of its ancestors, as mounted under root, with either
+// cgroup v2 (cpu.max) or v1 (cpu.cfs_quota_us and cpu.cfs_period_us).
+func cgroupCPUQuota(root string, procCgroup []byte) (int, bool) {
+	quota, found := 0, false
+	limit := func(q, period int64) {
+		if q <= 0 || period <= 0 {
+			return
+		}
+		n := int((q + period - 1) / period)
+		if !found || n < quota {
+			quota, found = n, true
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(procCgroup))
+	for scanner.Scan() {
+		// hierarchy-ID:controllers:path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			for _, dir := range cgroupDirs(root, fields[2]) {
+				data, err := ioutil.ReadFile(filepath.Join(dir, "cpu.max"))
+				if err != nil {
+					continue
+				}
+				// "max 100000" without a quota
+				values := strings.Fields(string(data))
+				if len(values) == 2 && values[0] != "max" {
+					limit(parseInt(values[0]), parseInt(values[1]))
+				}
+			}
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller != "cpu" {
+				continue
+			}
+			for _, dir := range cgroupDirs(filepath.Join(root, fields[1]), fields[2]) {
+				q, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+				if err != nil {
+					continue
+				}
+				period, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+				if err != nil {
+					continue
+				}
+				// -1 without a quota
+				limit(parseInt(string(q)), parseInt(string(period)))
+			}
+		}
+	}
+	return quota, found
+}
+
+// cgroupDirs returns the directory of a cgroup within the specified mount point and those of its
+// ancestors; in containers the cgroup path is usually the root of the mount point already.
+func cgroupDirs(mount, path string) []string {
+	var dirs []string
+	for {
+		dirs = append(dirs, filepath.Join(mount, path))
+		if path == "/" || path == "." || path == "" {
+			return dirs
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+func parseInt(s string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
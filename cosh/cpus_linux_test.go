@@ -0,0 +1,72 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFile(t *testing.T, root, path, data string) {
+	path = filepath.Join(root, path)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestCgroupCPUQuota(t *testing.T) {
+	root, err := ioutil.TempDir("", "coshell-cgroup")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	// cgroup v2, with the lowest quota on the parent
+	writeCgroupFile(t, root, "a/b/cpu.max", "250000 100000\n")
+	writeCgroupFile(t, root, "a/cpu.max", "150000 100000\n")
+	writeCgroupFile(t, root, "cpu.max", "max 100000\n")
+	quota, ok := cgroupCPUQuota(root, []byte("0::/a/b\n"))
+	if !ok || quota != 2 {
+		t.Errorf("expected quota of 2 CPUs but got %d (%v)", quota, ok)
+	}
+
+	// no quota
+	_, ok = cgroupCPUQuota(root, []byte("0::/\n"))
+	if ok {
+		t.Error("expected no quota")
+	}
+
+	// cgroup v1
+	writeCgroupFile(t, root, "cpu,cpuacct/c/cpu.cfs_quota_us", "400000\n")
+	writeCgroupFile(t, root, "cpu,cpuacct/c/cpu.cfs_period_us", "100000\n")
+	writeCgroupFile(t, root, "cpu,cpuacct/cpu.cfs_quota_us", "-1\n")
+	writeCgroupFile(t, root, "cpu,cpuacct/cpu.cfs_period_us", "100000\n")
+	quota, ok = cgroupCPUQuota(root, []byte("4:memory:/c\n3:cpu,cpuacct:/c\n"))
+	if !ok || quota != 4 {
+		t.Errorf("expected quota of 4 CPUs but got %d (%v)", quota, ok)
+	}
+}
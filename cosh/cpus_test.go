@@ -0,0 +1,58 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "testing"
+
+func TestParseJobs(t *testing.T) {
+	cpus := AvailableCPUs()
+	atLeast := func(n int) int {
+		if n < 1 {
+			return 1
+		}
+		return n
+	}
+	for s, expected := range map[string]int{
+		"0":     0,
+		"3":     3,
+		"100%":  atLeast(cpus),
+		"200%":  atLeast(cpus * 2),
+		"50%":   atLeast(cpus / 2),
+		"+2":    cpus + 2,
+		"-1":    atLeast(cpus - 1),
+		"-1000": 1,
+	} {
+		n, err := ParseJobs(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if n != expected {
+			t.Errorf("%q: expected %d but got %d", s, expected, n)
+		}
+	}
+
+	for _, s := range []string{"", "x", "0%", "-5%", "+x", "1.5"} {
+		_, err := ParseJobs(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
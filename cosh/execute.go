@@ -0,0 +1,362 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// runArgs runs a single process with the specified arguments and standard streams.
+func (cg *CommandGroup) runArgs(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd := cg.newCmd(args)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+
+	err := cg.start(cmd)
+	if err != nil {
+		return -1, err
+	}
+
+	return cg.wait(cmd)
+}
+
+// startExitCode returns the exit code of a command which could not be started because of err,
+// like a shell: 127 if it was not found and 126 if it could not be executed; false is returned
+// for other errors.
+func startExitCode(err error) (int, bool) {
+	switch {
+	case err == nil:
+		return 0, false
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, syscall.ENOENT):
+		return 127, true
+	case errors.Is(err, os.ErrPermission), errors.Is(err, syscall.ENOEXEC), errors.Is(err, syscall.EISDIR):
+		return 126, true
+	}
+	return 0, false
+}
+
+func (cg *CommandGroup) newCmd(args []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = cg.env
+	cmd.Dir = cg.dir
+	if cg.processGroups || cg.cgroup != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+		if cg.processGroups {
+			setProcessGroup(cmd.SysProcAttr)
+		}
+		if cg.cgroup != nil {
+			cg.cgroup.setSysProcAttr(cmd.SysProcAttr)
+		}
+	}
+	return cmd
+}
+
+// runScript runs all the lists of a script and returns the exit code of the last one;
+// the exit builtin stops the script with its exit code.
+func (cg *CommandGroup) runScript(s *script, stdin io.Reader) (int, error) {
+	cg.lastExitCode = 0
+	for _, list := range s.lists {
+		_, err := cg.runAndOrList(list, stdin)
+		if e, ok := err.(scriptExit); ok {
+			return int(e), nil
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+	return cg.lastExitCode, nil
+}
+
+// runAndOrList runs the pipelines of the list according to '&&' and '||' operators
+// and returns the exit code of the last pipeline which ran.
+func (cg *CommandGroup) runAndOrList(list *andOrList, stdin io.Reader) (int, error) {
+	exitCode, err := cg.runPipeline(list.pipelines[0], stdin)
+	if err != nil {
+		return -1, err
+	}
+	cg.lastExitCode = exitCode
+	for i, op := range list.operators {
+		if (op == "&&") != (exitCode == 0) {
+			continue
+		}
+		exitCode, err = cg.runPipeline(list.pipelines[i+1], stdin)
+		if err != nil {
+			return -1, err
+		}
+		cg.lastExitCode = exitCode
+	}
+	return exitCode, nil
+}
+
+// builtinResult is the outcome of a builtin running concurrently in a pipeline.
+type builtinResult struct {
+	exitCode int
+	err      error
+}
+
+// runPipeline starts all commands of the pipeline connected with pipes, waits for all of them
+// and returns the exit code of the last one. A builtin runs in this goroutine when it is
+// the only command of the pipeline, and concurrently otherwise.
+func (cg *CommandGroup) runPipeline(pl *pipeline, stdin io.Reader) (int, error) {
+	var (
+		waits []func() (int, error)
+		// files to be closed in this process once the command using them is started
+		files []*os.File
+	)
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		files = nil
+	}
+	defer closeFiles()
+
+	abort := func(err error) (int, error) {
+		// started commands will get EOF or EPIPE once files are closed
+		closeFiles()
+		for _, wait := range waits {
+			wait()
+		}
+		return -1, err
+	}
+	// like a shell, expansion and redirection errors only fail the command
+	fail := func(err error) (int, error) {
+		abort(nil)
+		fmt.Fprintf(cg.stderr, "coshell: %v\n", err)
+		return 1, nil
+	}
+
+	in := stdin
+	for i, sc := range pl.commands {
+		args, err := cg.expandWords(sc.words)
+		if err != nil {
+			return fail(err)
+		}
+		if len(args) == 0 {
+			return abort(ErrEmptyCommandLine)
+		}
+
+		// standard streams as file descriptors 0, 1 and 2
+		stdio := []interface{}{in, cg.stdout, cg.stderr}
+		in = nil
+		var next *os.File
+		if i < len(pl.commands)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				return abort(err)
+			}
+			files = append(files, w)
+			stdio[1] = w
+			next = r
+		}
+
+		for _, r := range sc.redirects {
+			f, err := cg.applyRedirect(stdio, r)
+			if err != nil {
+				if next != nil {
+					next.Close()
+				}
+				return fail(err)
+			}
+			if f != nil {
+				files = append(files, f)
+			}
+		}
+
+		b, isBuiltin := builtins[args[0]]
+		switch {
+		case isBuiltin && len(pl.commands) == 1:
+			return cg.runBuiltin(b, args, stdio, false)
+		case isBuiltin:
+			// the files of the builtin are closed once it returns
+			own := files
+			files = nil
+			result := make(chan builtinResult, 1)
+			go func() {
+				var r builtinResult
+				r.exitCode, r.err = cg.runBuiltin(b, args, stdio, true)
+				for _, f := range own {
+					f.Close()
+				}
+				result <- r
+			}()
+			waits = append(waits, func() (int, error) {
+				r := <-result
+				return r.exitCode, r.err
+			})
+		default:
+			cmd := cg.newCmd(args)
+			cmd.Stdin, _ = stdio[0].(io.Reader)
+			cmd.Stdout, _ = stdio[1].(io.Writer)
+			cmd.Stderr, _ = stdio[2].(io.Writer)
+
+			err = cg.start(cmd)
+			if exitCode, ok := startExitCode(err); ok {
+				// like a shell, only the command fails, with the rest of the pipeline running
+				fmt.Fprintf(cg.stderr, "coshell: %v\n", err)
+				closeFiles()
+				waits = append(waits, func() (int, error) {
+					return exitCode, nil
+				})
+				break
+			}
+			if err != nil {
+				if next != nil {
+					next.Close()
+				}
+				return abort(err)
+			}
+			closeFiles()
+			waits = append(waits, func() (int, error) {
+				return cg.wait(cmd)
+			})
+		}
+
+		if next != nil {
+			files = append(files, next)
+			in = next
+		}
+	}
+
+	var exitCode int
+	for i, wait := range waits {
+		var err error
+		exitCode, err = wait()
+		if err != nil {
+			// wait for the remaining commands
+			for _, wait := range waits[i+1:] {
+				wait()
+			}
+			return -1, err
+		}
+	}
+
+	return exitCode, nil
+}
+
+// applyRedirect modifies the standard streams according to the redirection; the file
+// opened for it, if any, is returned.
+func (cg *CommandGroup) applyRedirect(stdio []interface{}, r *redirect) (*os.File, error) {
+	if r.fd < 0 || r.fd >= len(stdio) {
+		return nil, fmt.Errorf("unsupported file descriptor %d in redirection", r.fd)
+	}
+
+	if r.op == ">&" || r.op == "<&" {
+		var src int
+		_, err := fmt.Sscan(r.target, &src)
+		if err != nil || src >= len(stdio) {
+			return nil, fmt.Errorf("unsupported file descriptor %s in redirection", r.target)
+		}
+		if _, ok := stdio[src].(io.Writer); r.op == ">&" && !ok {
+			return nil, fmt.Errorf("file descriptor %d is not open for writing", src)
+		}
+		if _, ok := stdio[src].(io.Reader); r.op == "<&" && !ok {
+			return nil, fmt.Errorf("file descriptor %d is not open for reading", src)
+		}
+		stdio[r.fd] = stdio[src]
+		return nil, nil
+	}
+
+	path, err := cg.expandWord(r.target)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cg.dir, path)
+	}
+
+	var f *os.File
+	switch r.op {
+	case "<":
+		f, err = os.Open(path)
+	case ">":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	case ">>":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	default:
+		panic("BUG: unexpected redirection operator " + r.op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stdio[r.fd] = f
+
+	return f, nil
+}
+
+// expandWords returns the arguments corresponding to the specified raw words.
+func (cg *CommandGroup) expandWords(words []string) ([]string, error) {
+	args := make([]string, 0, len(words))
+	for _, w := range words {
+		fields, err := cg.expandFields(w)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fields...)
+	}
+	return args, nil
+}
+
+// expandWord returns the single argument corresponding to a raw word.
+func (cg *CommandGroup) expandWord(raw string) (string, error) {
+	fields, err := cg.expandFields(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) != 1 {
+		return "", fmt.Errorf("%s: ambiguous redirect", raw)
+	}
+	return fields[0], nil
+}
+
+// expandFields returns the arguments corresponding to a raw word, removing quotes and escapes
+// and, if enabled, expanding parameters, tildes, braces and pathnames.
+func (cg *CommandGroup) expandFields(raw string) ([]string, error) {
+	s := splitter{operators: true}
+	if cg.expand {
+		s.parameters = true
+		s.expansion = &expansion{
+			env:     cg.env,
+			noUnset: cg.noUnset,
+		}
+	}
+	s.trackQuoting = cg.glob
+
+	var buf bytes.Buffer
+	word, _, err := s.splitWord(raw, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if !cg.glob {
+		return []string{word}, nil
+	}
+
+	var fields []string
+	for _, p := range expandBraces(pattern{word, s.quoted}) {
+		fields = append(fields, expandPathname(p, cg.dir)...)
+	}
+	return fields, nil
+}
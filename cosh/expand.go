@@ -0,0 +1,246 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/user"
+	"strings"
+)
+
+// ErrUnterminatedParameter is returned when a ${...} parameter expansion is unterminated.
+var ErrUnterminatedParameter = errors.New("Unterminated parameter expansion")
+
+// parameterOperators are the supported operators of ${name<op>word} expansions,
+// sorted so that the longest match comes first.
+var parameterOperators = []string{":-", ":+", ":?", "-", "+", "?"}
+
+// expansion holds the state used to expand parameters and tildes of words in shell-less mode.
+type expansion struct {
+	// env is the effective environment of the command
+	env []string
+	// noUnset makes the expansion of unset parameters an error, like 'set -u'
+	noUnset bool
+}
+
+// lookupEnv returns the value of a variable in the environment; the last definition wins,
+// as for os/exec.
+func lookupEnv(env []string, name string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], name) && len(env[i]) > len(name) && env[i][len(name)] == '=' {
+			return env[i][len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// nameLength returns the length of the variable name at the start of s.
+func nameLength(s string) int {
+	i := 0
+	for i < len(s) && isNameChar(s[i], i == 0) {
+		i++
+	}
+	return i
+}
+
+// closingBrace returns the index of the brace closing the parameter expansion at the start of s,
+// skipping quoted strings, escapes and nested expansions; -1 is returned if there is none.
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j == -1 {
+				return -1
+			}
+			i += j + 1
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				return -1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parameter expands the parameter at the start of input, which follows a '$', and returns
+// the remainder of input; a '$' not followed by a valid parameter is kept as-is.
+func (s *splitter) parameter(input string, buf *bytes.Buffer) (string, error) {
+	if !strings.HasPrefix(input, "{") {
+		n := nameLength(input)
+		if n == 0 {
+			s.write(buf, "$", true)
+			return input, nil
+		}
+		if s.expansion == nil {
+			s.write(buf, "$"+input[:n], true)
+			return input[n:], nil
+		}
+		value, err := s.expansion.expand(input[:n], "", "", s)
+		s.write(buf, value, true)
+		return input[n:], err
+	}
+
+	end := closingBrace(input)
+	if end == -1 {
+		return "", ErrUnterminatedParameter
+	}
+	body := input[1:end]
+
+	n := nameLength(body)
+	name, op, word := body[:n], "", body[n:]
+	if word != "" {
+		for _, o := range parameterOperators {
+			if strings.HasPrefix(word, o) {
+				op, word = o, word[len(o):]
+				break
+			}
+		}
+	}
+	if n == 0 || (op == "" && word != "") {
+		return "", fmt.Errorf("${%s}: bad substitution", body)
+	}
+
+	if s.expansion == nil {
+		// validate the word
+		_, _, err := s.wordSplitter().splitWord(word, &bytes.Buffer{})
+		if err != nil {
+			return "", err
+		}
+		s.write(buf, "${"+body+"}", true)
+		return input[end+1:], nil
+	}
+
+	value, err := s.expansion.expand(name, op, word, s)
+	s.write(buf, value, true)
+	return input[end+1:], err
+}
+
+// wordSplitter returns a splitter for the word of ${name<op>word} parameter expansions.
+func (s *splitter) wordSplitter() *splitter {
+	return &splitter{
+		keepSpaces: true,
+		parameters: true,
+		expansion:  s.expansion,
+	}
+}
+
+// expand returns the value of the named parameter according to the ${name<op>word} operator.
+func (e *expansion) expand(name, op, word string, s *splitter) (string, error) {
+	value, set := lookupEnv(e.env, name)
+	if op == "" {
+		if !set && e.noUnset {
+			return "", fmt.Errorf("%s: parameter not set", name)
+		}
+		return value, nil
+	}
+
+	// with a colon, an empty parameter is treated as unset
+	null := !set || (op[0] == ':' && value == "")
+
+	switch strings.TrimPrefix(op, ":") {
+	case "-":
+		if !null {
+			return value, nil
+		}
+	case "+":
+		if null {
+			return "", nil
+		}
+	case "?":
+		if !null {
+			return value, nil
+		}
+	}
+
+	expanded, _, err := s.wordSplitter().splitWord(word, &bytes.Buffer{})
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(op, "?") {
+		if expanded == "" {
+			expanded = "parameter null or not set"
+		}
+		return "", fmt.Errorf("%s: %s", name, expanded)
+	}
+
+	return expanded, nil
+}
+
+// tilde expands a tilde-prefix at the start of input, writing the home directory to buf,
+// and returns the remainder of input; input is returned unchanged if there is no valid tilde-prefix
+// or if the home directory cannot be found.
+func (e *expansion) tilde(input string, buf *bytes.Buffer, s *splitter) string {
+	if !strings.HasPrefix(input, "~") {
+		return input
+	}
+	end := strings.IndexFunc(input, func(c rune) bool {
+		return c == '/' || (!s.keepSpaces && strings.ContainsRune(splitChars, c)) ||
+			(s.operators && strings.ContainsRune(operatorChars, c))
+	})
+	if end == -1 {
+		end = len(input)
+	}
+	login := input[1:end]
+	if strings.ContainsAny(login, "'\"\\$") {
+		// quoted tilde-prefixes are not expanded
+		return input
+	}
+
+	var home string
+	if login == "" {
+		var ok bool
+		home, ok = lookupEnv(e.env, "HOME")
+		if !ok {
+			return input
+		}
+	} else {
+		u, err := user.Lookup(login)
+		if err != nil {
+			return input
+		}
+		home = u.HomeDir
+	}
+
+	s.write(buf, home, true)
+	return input[end:]
+}
@@ -0,0 +1,78 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExpandWord(t *testing.T) {
+	e := &expansion{env: []string{"HOME=/home/test", "A=alpha", "EMPTY=", "A=beta"}}
+	s := splitter{operators: true, parameters: true, expansion: e}
+
+	for _, tc := range []struct {
+		raw, expected string
+	}{
+		{"$A", "beta"},
+		{"${A}x", "betax"},
+		{"'$A'", "$A"},
+		{"\"$A\"", "beta"},
+		{"\\$A", "$A"},
+		{"$UNSET-", "-"},
+		{"${UNSET:-default value}", "default value"},
+		{"${EMPTY:-default}", "default"},
+		{"${EMPTY-default}", ""},
+		{"${UNSET:-\"$A\"}", "beta"},
+		{"${UNSET:-${EMPTY:-nested}}", "nested"},
+		{"${A:+set}", "set"},
+		{"${UNSET:+set}", ""},
+		{"$", "$"},
+		{"a$1", "a$1"},
+		{"~", "/home/test"},
+		{"~/bin", "/home/test/bin"},
+		{"'~'/bin", "~/bin"},
+		{"a~", "a~"},
+	} {
+		actual, _, err := s.splitWord(tc.raw, &bytes.Buffer{})
+		if err != nil {
+			t.Errorf("%q: %v", tc.raw, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("%q: expected %q but got %q", tc.raw, tc.expected, actual)
+		}
+	}
+
+	for _, raw := range []string{"${A", "${}", "${#A}", "${UNSET:?missing}", "${EMPTY:?}"} {
+		_, _, err := s.splitWord(raw, &bytes.Buffer{})
+		if err == nil {
+			t.Errorf("%q: expected error", raw)
+		}
+	}
+
+	e.noUnset = true
+	if _, _, err := s.splitWord("$UNSET", &bytes.Buffer{}); err == nil {
+		t.Error("expected error for unset parameter")
+	}
+	if _, _, err := s.splitWord("${UNSET-ok}", &bytes.Buffer{}); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,338 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const globChars = "*?["
+
+// pattern is a word with the quoting of each of its bytes; only unquoted bytes
+// are special for brace and pathname expansion.
+type pattern struct {
+	text   string
+	quoted []bool
+}
+
+func (p pattern) special(i int, c byte) bool {
+	return p.text[i] == c && !p.quoted[i]
+}
+
+func (p pattern) slice(from, to int) pattern {
+	return pattern{p.text[from:to], p.quoted[from:to]}
+}
+
+func (p pattern) isQuoted() bool {
+	for _, q := range p.quoted {
+		if q {
+			return true
+		}
+	}
+	return false
+}
+
+func concatPatterns(patterns ...pattern) pattern {
+	var r pattern
+	for _, p := range patterns {
+		r.text += p.text
+		r.quoted = append(r.quoted, p.quoted...)
+	}
+	return r
+}
+
+// expandBraces performs bash-style brace expansion of lists, e.g. "a{b,c}d" to "abd" and "acd",
+// and of integer or character ranges with an optional increment, e.g. "{1..10..2}" or "{a..e}".
+// Braces which do not form a valid expansion are kept verbatim.
+func expandBraces(p pattern) []pattern {
+	for open := 0; open < len(p.text); open++ {
+		if !p.special(open, '{') {
+			continue
+		}
+
+		end := -1
+		var commas []int
+		depth := 0
+	scan:
+		for i := open + 1; i < len(p.text); i++ {
+			if p.quoted[i] {
+				continue
+			}
+			switch p.text[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					end = i
+					break scan
+				}
+				depth--
+			case ',':
+				if depth == 0 {
+					commas = append(commas, i)
+				}
+			}
+		}
+		if end == -1 {
+			// no more closing braces
+			break
+		}
+
+		var items []pattern
+		if len(commas) != 0 {
+			start := open + 1
+			for _, i := range append(commas, end) {
+				items = append(items, p.slice(start, i))
+				start = i + 1
+			}
+		} else {
+			items = braceRange(p.slice(open+1, end))
+			if items == nil {
+				// try with the next opening brace
+				continue
+			}
+		}
+
+		prefix := p.slice(0, open)
+		suffixes := expandBraces(p.slice(end+1, len(p.text)))
+		var expanded []pattern
+		for _, item := range items {
+			for _, alt := range expandBraces(item) {
+				for _, suffix := range suffixes {
+					expanded = append(expanded, concatPatterns(prefix, alt, suffix))
+				}
+			}
+		}
+		return expanded
+	}
+
+	return []pattern{p}
+}
+
+// braceRange returns the items of a range brace expansion, or nil if p is not a valid range.
+func braceRange(p pattern) []pattern {
+	if p.isQuoted() {
+		return nil
+	}
+	parts := strings.Split(p.text, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil
+	}
+	step := 1
+	if len(parts) == 3 {
+		var err error
+		step, err = strconv.Atoi(parts[2])
+		if err != nil {
+			return nil
+		}
+		if step < 0 {
+			step = -step
+		}
+		if step == 0 {
+			step = 1
+		}
+	}
+
+	var (
+		items    []string
+		from, to int
+		format   string
+	)
+	if a, err := strconv.Atoi(parts[0]); err == nil {
+		b, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil
+		}
+		from, to, format = a, b, "%d"
+		// zero-padding is used when any of the numbers has leading zeroes
+		if hasLeadingZero(parts[0]) || hasLeadingZero(parts[1]) {
+			width := len(parts[0])
+			if len(parts[1]) > width {
+				width = len(parts[1])
+			}
+			format = "%0" + strconv.Itoa(width) + "d"
+		}
+	} else if isRangeChar(parts[0]) && isRangeChar(parts[1]) {
+		from, to, format = int(parts[0][0]), int(parts[1][0]), "%c"
+	} else {
+		return nil
+	}
+
+	if from > to {
+		step = -step
+	}
+	for i := from; (step > 0 && i <= to) || (step < 0 && i >= to); i += step {
+		items = append(items, fmt.Sprintf(format, i))
+	}
+
+	patterns := make([]pattern, len(items))
+	for i, item := range items {
+		patterns[i] = pattern{item, make([]bool, len(item))}
+	}
+	return patterns
+}
+
+func hasLeadingZero(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+func isRangeChar(s string) bool {
+	return len(s) == 1 && ((s[0] >= 'a' && s[0] <= 'z') || (s[0] >= 'A' && s[0] <= 'Z'))
+}
+
+// expandPathname performs pathname expansion of the pattern, relative to dir if not absolute;
+// like sh, the pattern is kept verbatim if it matches no files, and files starting with a dot
+// are matched only if explicitly specified.
+func expandPathname(p pattern, dir string) []string {
+	hasGlob := false
+	var b strings.Builder
+	for i := 0; i < len(p.text); i++ {
+		c := p.text[i]
+		if !p.quoted[i] {
+			hasGlob = hasGlob || strings.IndexByte(globChars, c) != -1
+		} else if strings.IndexByte(globChars+"]\\", c) != -1 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	if !hasGlob {
+		return []string{p.text}
+	}
+
+	names, err := glob(dir, b.String())
+	if err != nil || len(names) == 0 {
+		// malformed or unmatched patterns are not expanded
+		return []string{p.text}
+	}
+
+	return names
+}
+
+// globMatch is a path matched while expanding a pattern.
+type globMatch struct {
+	// path is the path in the filesystem
+	path string
+	// text is the path as it will be expanded, relative to the directory if the pattern is relative
+	text string
+}
+
+// glob returns the paths matching the pattern, sorted and in the same form as the pattern itself
+// (i.e. not cleaned); relative patterns are matched in dir.
+func glob(dir, pattern string) ([]string, error) {
+	sep := string(filepath.Separator)
+	parts := strings.Split(pattern, sep)
+	matches := []globMatch{{path: dir}}
+	if strings.HasPrefix(pattern, sep) {
+		matches = []globMatch{{path: sep, text: sep}}
+		parts = parts[1:]
+	}
+
+	for i, part := range parts {
+		// all but the last component must be directories
+		last := i == len(parts)-1
+		var next []globMatch
+		for _, m := range matches {
+			prefix := m.text
+			if prefix != "" && !strings.HasSuffix(prefix, sep) {
+				prefix += sep
+			}
+
+			if !hasGlobChars(part) {
+				name := unescapeGlob(part)
+				next = append(next, globMatch{filepath.Join(m.path, name), prefix + name})
+				continue
+			}
+
+			f, err := os.Open(m.path)
+			if err != nil {
+				continue
+			}
+			names, err := f.Readdirnames(-1)
+			f.Close()
+			if err != nil {
+				continue
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				if strings.HasPrefix(name, ".") && !strings.HasPrefix(part, ".") && !strings.HasPrefix(part, "\\.") {
+					continue
+				}
+				ok, err := filepath.Match(part, name)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+				path := filepath.Join(m.path, name)
+				if !last {
+					if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+						continue
+					}
+				}
+				next = append(next, globMatch{path, prefix + name})
+			}
+		}
+		matches = next
+	}
+
+	var names []string
+	for _, m := range matches {
+		// components without glob characters might not exist
+		if _, err := os.Lstat(m.path); err == nil {
+			names = append(names, m.text)
+		}
+	}
+
+	return names, nil
+}
+
+// hasGlobChars returns true if the pattern has unescaped glob characters.
+func hasGlobChars(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte(globChars, pattern[i]) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeGlob removes escapes from a pattern without glob characters.
+func unescapeGlob(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
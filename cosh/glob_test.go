@@ -0,0 +1,116 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// expandTestWord splits a raw word and performs brace and pathname expansion on it.
+func expandTestWord(t *testing.T, raw, dir string) []string {
+	s := splitter{operators: true, trackQuoting: true}
+	word, _, err := s.splitWord(raw, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var fields []string
+	for _, p := range expandBraces(pattern{word, s.quoted}) {
+		fields = append(fields, expandPathname(p, dir)...)
+	}
+	return fields
+}
+
+func TestExpandBraces(t *testing.T) {
+	for _, tc := range []struct {
+		raw, expected string
+	}{
+		{"a{b,c}d", "abd acd"},
+		{"{a,b}{1,2}", "a1 a2 b1 b2"},
+		{"x{a,b{1..3}}", "xa xb1 xb2 xb3"},
+		{"{1..10..3}", "1 4 7 10"},
+		{"{3..1}", "3 2 1"},
+		{"{08..10}", "08 09 10"},
+		{"{c..a}", "c b a"},
+		{"{a,}b", "ab b"},
+		{"'{a,b}'", "{a,b}"},
+		{"{a\\,b}", "{a,b}"},
+		{"\"{\"a,b}", "{a,b}"},
+		{"{a}", "{a}"},
+		{"{a..}", "{a..}"},
+		{"{x{a,b}}", "{xa} {xb}"},
+	} {
+		actual := strings.Join(expandTestWord(t, tc.raw, "."), " ")
+		if actual != tc.expected {
+			t.Errorf("%q: expected %q but got %q", tc.raw, tc.expected, actual)
+		}
+	}
+}
+
+func TestExpandPathname(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-glob")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.img", "b.img", ".hidden.img", "sub/c.img", "sub/d.txt", "x*y"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	for _, tc := range []struct {
+		raw, expected string
+	}{
+		{"*.img", "a.img b.img"},
+		{".*.img", ".hidden.img"},
+		{"[ab].img", "a.img b.img"},
+		{"?.img", "a.img b.img"},
+		{"*/", "sub/"},
+		{"*/*.img", "sub/c.img"},
+		{"sub/../*.img", "sub/../a.img sub/../b.img"},
+		{"'*'.img", "*.img"},
+		{"\\*.img", "*.img"},
+		{"x'*'y", "x*y"},
+		{"x*", "x*y"},
+		{"none*", "none*"},
+		{"[", "["},
+		{"{a,b}.im?", "a.img b.img"},
+	} {
+		actual := strings.Join(expandTestWord(t, tc.raw, dir), " ")
+		if actual != tc.expected {
+			t.Errorf("%q: expected %q but got %q", tc.raw, tc.expected, actual)
+		}
+	}
+
+	matches := expandTestWord(t, filepath.Join(dir, "*.img"), ".")
+	if len(matches) != 2 || matches[0] != filepath.Join(dir, "a.img") {
+		t.Errorf("unexpected matches for absolute pattern: %q", matches)
+	}
+}
@@ -20,116 +20,544 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package cosh
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"sync"
 	"syscall"
+	"time"
 )
 
+// errTerminated is returned when a process is about to be started in a terminated command group.
+var errTerminated = errors.New("command group was terminated")
+
+// GroupMode specifies how the exit codes of the commands of a group are combined.
+type GroupMode int
+
+const (
+	// GroupAnd runs commands until one fails and uses its exit code, like '&&'.
+	GroupAnd GroupMode = iota
+	// GroupAll runs all commands and uses the worst exit code, like ';'.
+	GroupAll
+	// GroupOr runs commands until one succeeds; the exit code of the last one is used, like '||'.
+	GroupOr
+)
+
+// ParseGroupMode parses a group mode, one of '&&' (or 'and'), ';' (or 'all') and '||' (or 'or').
+func ParseGroupMode(s string) (GroupMode, error) {
+	switch s {
+	case "&&", "and":
+		return GroupAnd, nil
+	case ";", "all":
+		return GroupAll, nil
+	case "||", "or":
+		return GroupOr, nil
+	}
+	return GroupAnd, fmt.Errorf("invalid group mode: %q", s)
+}
+
+// String returns the operator corresponding to the group mode.
+func (m GroupMode) String() string {
+	switch m {
+	case GroupAll:
+		return ";"
+	case GroupOr:
+		return "||"
+	}
+	return "&&"
+}
+
 // CommandGroup is a group of commands.
 type CommandGroup struct {
-	commands []*exec.Cmd
+	// index is the position of the group in the command pool
+	index int
+	// name is the name of the job or the label of the group in the input, if any
+	name     string
+	commands []*command
+	mode     GroupMode
+	// finally are the commands always run after the group, even if it failed or was terminated
+	finally []*command
+	stdin   stdinSource
+	// timeout is the maximum duration of the group, including retries, if not zero
+	timeout time.Duration
+	retries int
+	restart RestartConfig
+	// stopSignal is sent to running processes when the group is stopped, before killing them
+	// after stopGrace; if zero, processes are killed right away
+	stopSignal   syscall.Signal
+	stopGrace    time.Duration
+	stopPriority int
+	// nice and ionice are the priorities of processes, if not zero
+	nice   int
+	ionice IOPriority
+	// rlimits are the resource limits of processes
+	rlimits []Rlimit
+	// cgroup, if not nil, is the cgroup processes start in, with cgroupLimits; peakMemory is
+	// its peak memory usage, recorded once it is removed
+	cgroup       *cgroup
+	cgroupLimits CgroupLimits
+	peakMemory   int64
+	// after are the names of the groups which must complete successfully before this one starts
+	after []string
+	tags  []string
+	// done is closed once the group completed, with its exit code in exitCode
+	done     chan struct{}
+	exitCode int
+	// ready is closed once the readiness probe succeeded; it is nil for groups without one
+	ready      chan struct{}
+	readyOnce  sync.Once
+	readyProbe ReadyProbe
+	// probe checks the readiness probe; it is nil for probes matching the output
+	probe func() bool
+	// probeGroup runs the command of command probes
+	probeGroup *CommandGroup
+
+	// baseDir and baseEnv are the initial working directory and environment, while dir and env
+	// are the current ones, changed by builtins
+	baseDir        string
+	baseEnv        []string
+	dir            string
+	env            []string
+	stdout, stderr io.Writer
+	// expand, noUnset and glob control expansion of words in shell-less mode
+	expand, noUnset, glob bool
+	// lastExitCode is the exit code of the last pipeline run in shell-less mode, like $?
+	lastExitCode int
 
 	sync.Mutex
-	finished []bool
-	started  []bool
+	running    map[*exec.Cmd]struct{}
+	terminated bool
+	// processGroups is true when each process is started in its own process group, which is
+	// tracked in pgroups by process group ID until none of its processes is left
+	processGroups bool
+	pgroups       map[int]*exec.Cmd
+	// finalizing is true while finally commands run; they are neither refused nor killed
+	// because of termination, unless killed is true
+	finalizing bool
+	killed     bool
+	// killedC is closed when killed becomes true
+	killedC chan struct{}
+	// terminatedC is closed when the command group is terminated
+	terminatedC chan struct{}
+	timedOut    bool
+}
+
+// command is a command line of a command group; it is parsed when the command group
+// is created and processes are created for it only when it runs.
+type command struct {
+	line string
+	// args is the command to run when a shell is used
+	args []string
+	// script is the parsed command line when no shell is used
+	script *script
 }
 
-// NewCommandGroup constructs a new CommandGroup; stdin is not attached to commands.
+// NewCommandGroup constructs a new CommandGroup; stdin is not attached to commands,
+// unless later configured by the command pool.
 func (cp *CommandPool) NewCommandGroup(cwd string, env []string, stdout, stderr io.Writer, commandLines []string) (*CommandGroup, error) {
-	var cg CommandGroup
-	l := len(commandLines)
-	cg.commands = make([]*exec.Cmd, l)
-	cg.finished = make([]bool, l)
-	cg.started = make([]bool, l)
+	cg := CommandGroup{
+		commands: make([]*command, len(commandLines)),
+		baseDir:  cwd,
+		baseEnv:  env,
+		dir:      cwd,
+		env:      env,
+		stdout:   stdout,
+		stderr:   stderr,
+		expand:   cp.Expand,
+		noUnset:  cp.NoUnset,
+		glob:     cp.Glob,
+		running:  map[*exec.Cmd]struct{}{},
+		pgroups:  map[int]*exec.Cmd{},
+
+		processGroups: cp.ProcessGroups,
+
+		terminatedC: make(chan struct{}),
+		killedC:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
 	for j, commandLine := range commandLines {
 		cmd, err := cp.prepareCommand(commandLine)
 		if err != nil {
-			// will only happen in case of problems at splitting the command line
+			// will only happen in case of problems at parsing the command line
 			return nil, err
 		}
 		cg.commands[j] = cmd
-
-		cmd.Env = env
-		cmd.Dir = cwd
-		cmd.Stdout, cmd.Stderr = stdout, stderr
-		// notice here how no stdin is attached to commands
 	}
 
 	return &cg, nil
 }
 
-func (cg *CommandGroup) setFinished(i int) {
-	cg.Lock()
-	cg.started[i] = false
-	cg.finished[i] = true
-	cg.Unlock()
+// setFinally sets the commands always run after the group.
+func (cg *CommandGroup) setFinally(cp *CommandPool, commandLines []string) error {
+	cg.finally = make([]*command, len(commandLines))
+	for j, commandLine := range commandLines {
+		cmd, err := cp.prepareCommand(commandLine)
+		if err != nil {
+			return err
+		}
+		cg.finally[j] = cmd
+	}
+	return nil
 }
 
-// Run will synchronously run all the commands of the command group.
+// Run will synchronously run all the commands of the command group according to its mode,
+// followed by the finally commands, restarting them according to the restart policy.
 func (cg *CommandGroup) Run() (int, error) {
 	if cg == nil {
 		panic("BUG: cg is nil")
 	}
-	for i := range cg.commands {
+
+	if cg.timeout > 0 {
+		timer := time.AfterFunc(cg.timeout, func() {
+			cg.Lock()
+			cg.timedOut = true
+			cg.Unlock()
+			cg.stop()
+		})
+		defer timer.Stop()
+	}
+
+	if cg.probe != nil {
+		stop := make(chan struct{})
+		go cg.checkReady(stop)
+		defer func() {
+			close(stop)
+			if cg.probeGroup != nil {
+				cg.probeGroup.terminate()
+			}
+		}()
+	}
+
+	r := restarter{RestartConfig: cg.restart}
+	for {
+		exitCode, err := cg.runOnce()
+		if err != nil || cg.isTerminated() {
+			return exitCode, err
+		}
+		restart, delay, msg := r.next(exitCode, time.Now())
+		if msg != "" {
+			fmt.Fprintf(cg.stderr, "coshell: %s exited with code %d and was not restarted: %s\n", cg.displayName(), exitCode, msg)
+		}
+		if !restart {
+			return exitCode, nil
+		}
+
+		fmt.Fprintf(cg.stderr, "coshell: %s exited with code %d, restarting in %v\n", cg.displayName(), exitCode, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-cg.terminatedC:
+			timer.Stop()
+			return exitCode, nil
+		}
+	}
+}
+
+// runOnce runs the commands of the group, retrying them if they fail, and the finally commands.
+func (cg *CommandGroup) runOnce() (int, error) {
+	var (
+		exitCode int
+		err      error
+	)
+	for attempt := 0; ; attempt++ {
+		// builtins of previous attempts must not affect the next one
+		cg.dir, cg.env = cg.baseDir, cg.baseEnv
+		exitCode, err = cg.withStdin(cg.runCommands)
+		if err != nil || exitCode == 0 || attempt == cg.retries || cg.isTerminated() {
+			break
+		}
+		fmt.Fprintf(cg.stderr, "coshell: %s failed with exit code %d, retrying (%d/%d)\n", cg.displayName(), exitCode, attempt+1, cg.retries)
+	}
+	cg.Lock()
+	timedOut := cg.timedOut
+	cg.Unlock()
+	if timedOut {
+		fmt.Fprintf(cg.stderr, "coshell: %s timed out after %v\n", cg.displayName(), cg.timeout)
+		exitCode = timeoutExitCode
+	}
+
+	if len(cg.finally) != 0 {
+		cg.Lock()
+		cg.finalizing = true
+		cg.Unlock()
+
+		finallyExitCode, finallyErr := cg.withStdin(cg.runFinally)
+		if finallyErr != nil && err == nil {
+			exitCode, err = -1, finallyErr
+		}
+		// a failure of finally commands fails a successful group
+		if exitCode == 0 && err == nil {
+			exitCode = finallyExitCode
+		}
+
 		cg.Lock()
-		err := cg.commands[i].Start()
+		cg.finalizing = false
+		cg.Unlock()
+	}
+
+	return exitCode, err
+}
+
+// withStdin opens the standard input of the group and runs f with it; commands in sequence
+// share the same input, like a shell compound command.
+func (cg *CommandGroup) withStdin(f func(stdin io.Reader) (int, error)) (int, error) {
+	var stdin io.Reader
+	if cg.stdin != nil {
+		var (
+			closer io.Closer
+			err    error
+		)
+		stdin, closer, err = cg.stdin()
 		if err != nil {
-			// always invalidate command after exit
-			cg.finished[i] = true
-			cg.Unlock()
 			return -1, err
 		}
-		cg.started[i] = true
+		if closer != nil {
+			defer closer.Close()
+		}
+	}
+	return f(stdin)
+}
+
+// runFinally runs all the finally commands of the group; the exit code is that of the first
+// failed command, if any.
+func (cg *CommandGroup) runFinally(stdin io.Reader) (int, error) {
+	var (
+		result int
+		err    error
+	)
+	for _, cmd := range cg.finally {
+		cg.Lock()
+		killed := cg.killed
 		cg.Unlock()
+		var (
+			exitCode int
+			cmdErr   error
+		)
+		if killed {
+			// builtins would run without starting any process
+			cmdErr = errTerminated
+		} else {
+			exitCode, cmdErr = cg.runCommand(cmd, stdin)
+		}
+		if cmdErr == errTerminated {
+			// killed, like a terminated group
+			if result == 0 {
+				result = -1
+			}
+			break
+		}
+		if cmdErr != nil && err == nil {
+			err = cmdErr
+		}
+		if result == 0 {
+			result = exitCode
+		}
+	}
+	return result, err
+}
 
-		err = cg.commands[i].Wait()
-		// always invalidate command after exit
-		cg.setFinished(i)
-		if err == nil {
-			// exit code is 0, pick next command
-			continue
+// runCommands runs the commands of the group according to its mode.
+func (cg *CommandGroup) runCommands(stdin io.Reader) (int, error) {
+	var result int
+	for i, cmd := range cg.commands {
+		if cg.isTerminated() {
+			// builtins would run without starting any process
+			return -1, nil
+		}
+		exitCode, err := cg.runCommand(cmd, stdin)
+		if err == errTerminated {
+			return -1, nil
 		}
-		if exitError, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
-				//NOTE: this is never expected to happen with exit code 0
-				return status.ExitStatus(), nil
+		if err != nil {
+			return exitCode, err
+		}
+
+		switch cg.mode {
+		case GroupAnd:
+			if exitCode != 0 {
+				return exitCode, nil
+			}
+		case GroupAll:
+			// -1 is the worst, as it is used for terminated groups
+			if i == 0 || uint(exitCode) > uint(result) {
+				result = exitCode
 			}
-			return -1, fmt.Errorf("cannot read exit error status: %w", err)
+		case GroupOr:
+			if exitCode == 0 {
+				return 0, nil
+			}
+			result = exitCode
 		}
+	}
+
+	return result, nil
+}
 
-		// any other error
-		return -1, err
+// waitDependencies waits for the specified groups to complete, or to be ready if they have
+// a readiness probe, and returns 0 if all of them succeeded; otherwise the group does not run
+// and the returned exit code is used for it.
+func (cg *CommandGroup) waitDependencies(groups []*CommandGroup, deps []int) int {
+	for _, d := range deps {
+		dep := groups[d]
+		// ready is nil, thus never selected, for groups without a readiness probe
+		select {
+		case <-dep.ready:
+			continue
+		case <-dep.done:
+		case <-cg.terminatedC:
+			// dependencies are stopped after the groups depending on them
+			return -1
+		}
+		// a successful completion counts as ready
+		if dep.exitCode == 0 || dep.isReady() {
+			continue
+		}
+		if cg.isTerminated() {
+			return -1
+		}
+		if dep.ready != nil {
+			fmt.Fprintf(cg.stderr, "coshell: %s not started because %s failed before being ready\n", cg.displayName(), dep.displayName())
+		} else {
+			fmt.Fprintf(cg.stderr, "coshell: %s not started because %s failed\n", cg.displayName(), dep.displayName())
+		}
+		return 1
 	}
+	return 0
+}
 
-	// all commands completed successfully - exit code 0
-	return 0, nil
+// displayName returns the name of the group for messages.
+func (cg *CommandGroup) displayName() string {
+	if cg.name != "" {
+		return cg.name
+	}
+	return fmt.Sprintf("group #%d", cg.index)
 }
 
-func (cg *CommandGroup) terminate() {
+func (cg *CommandGroup) isTerminated() bool {
 	cg.Lock()
 	defer cg.Unlock()
+	return cg.terminated
+}
 
-	for i, cmd := range cg.commands {
-		// already finished
-		if cg.finished[i] {
-			continue
-		}
+// runCommand runs a single command of the group and returns its exit code.
+func (cg *CommandGroup) runCommand(cmd *command, stdin io.Reader) (int, error) {
+	if cmd.script != nil {
+		return cg.runScript(cmd.script, stdin)
+	}
+	return cg.runArgs(cmd.args, stdin, cg.stdout, cg.stderr)
+}
 
-		// not yet started
-		if !cg.started[i] {
-			continue
+// start starts the specified command and tracks its process until waited for with wait;
+// no process is started once the command group has been terminated.
+func (cg *CommandGroup) start(cmd *exec.Cmd) error {
+	cg.Lock()
+	defer cg.Unlock()
+
+	if cg.terminated && (!cg.finalizing || cg.killed) {
+		return errTerminated
+	}
+	err := cg.wrap(cmd)
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	cg.running[cmd] = struct{}{}
+	if cg.processGroups {
+		cg.pgroups[cmd.Process.Pid] = cmd
+	}
+
+	return nil
+}
+
+// wait waits for the specified command to exit and returns its exit code.
+func (cg *CommandGroup) wait(cmd *exec.Cmd) (int, error) {
+	err := cmd.Wait()
+
+	// always invalidate command after exit
+	cg.Lock()
+	delete(cg.running, cmd)
+	cg.Unlock()
+
+	return exitStatus(err)
+}
+
+// exitStatus returns the exit code corresponding to the error returned by exec.Cmd.Wait.
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	if exitError, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
+			//NOTE: this is never expected to happen with exit code 0
+			return status.ExitStatus(), nil
 		}
+		return -1, fmt.Errorf("cannot read exit error status: %w", err)
+	}
+
+	// any other error
+	return -1, err
+}
 
+// terminate terminates the command group, killing its running processes.
+func (cg *CommandGroup) terminate() {
+	cg.signal(syscall.SIGKILL, false)
+}
+
+// kill terminates the command group, killing all its processes including those of finally commands.
+func (cg *CommandGroup) kill() {
+	cg.signal(syscall.SIGKILL, true)
+}
+
+// signal terminates the command group, sending the specified signal to its running processes;
+// processes of finally commands are signalled only if all is true.
+func (cg *CommandGroup) signal(sig syscall.Signal, all bool) {
+	cg.Lock()
+	defer cg.Unlock()
+
+	if !cg.terminated {
+		cg.terminated = true
+		close(cg.terminatedC)
+	}
+	if all && !cg.killed {
+		// finally commands are not started anymore either
+		cg.killed = true
+		close(cg.killedC)
+	} else if cg.finalizing && !all {
+		return
+	}
+	for cmd := range cg.running {
 		if cmd.Process == nil {
-			panic("BUG: unexpected process missing after call to Stat")
+			panic("BUG: unexpected process missing after call to Start")
+		}
+		if cg.processGroups {
+			// signalled below with its process group
+			continue
 		}
 
-		err := cmd.Process.Kill()
+		err := cmd.Process.Signal(sig)
 		if err != nil && err.Error() != "os: process already finished" {
-			fmt.Fprintf(os.Stderr, "ERROR: could not kill process %d: %v\n", cmd.Process.Pid, err)
+			fmt.Fprintf(os.Stderr, "ERROR: could not signal process %d: %v\n", cmd.Process.Pid, err)
+		}
+	}
+	// process groups include descendants, which can be left behind by processes already exited
+	for pgid := range cg.pgroups {
+		err := signalProcessGroup(pgid, sig)
+		if err == syscall.ESRCH {
+			delete(cg.pgroups, pgid)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: could not signal process group %d: %v\n", pgid, err)
+		}
+	}
+	// and so does the cgroup, which cannot be escaped
+	if sig == syscall.SIGKILL && cg.cgroup != nil {
+		err := cg.cgroup.kill()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: could not kill cgroup %s: %v\n", cg.cgroup.dir, err)
 		}
 	}
 }
@@ -0,0 +1,95 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGroupModes(t *testing.T) {
+	for _, tc := range []struct {
+		group    InputGroup
+		exitCode int
+		output   string
+	}{
+		{InputGroup{CommandLines: []string{"echo a", "exit 3", "echo b"}}, 3, "a\n"},
+		{InputGroup{CommandLines: []string{"exit 2", "exit 5", "echo b", "exit 1"}, Mode: GroupAll}, 5, "b\n"},
+		{InputGroup{CommandLines: []string{"exit 2", "echo a", "echo b"}, Mode: GroupOr}, 0, "a\n"},
+		{InputGroup{CommandLines: []string{"exit 2", "exit 4"}, Mode: GroupOr}, 4, ""},
+		{InputGroup{CommandLines: []string{"exit 2"}, Finally: []string{"echo f", "exit 7"}}, 2, "f\n"},
+		{InputGroup{CommandLines: []string{"echo a"}, Finally: []string{"exit 7", "echo f"}}, 7, "a\nf\n"},
+	} {
+		var buf bytes.Buffer
+		cfg := DefaultCommandPoolConfig
+		cfg.Stdout = &buf
+
+		cp := NewCommandPool(&cfg)
+		err := cp.AddGroups(tc.group)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = cp.Start(0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		exitCode, err := cp.Join()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if exitCode != tc.exitCode {
+			t.Errorf("%v: expected exit code %d but got %d", tc.group, tc.exitCode, exitCode)
+		}
+		if buf.String() != tc.output {
+			t.Errorf("%v: expected output %q but got %q", tc.group, tc.output, buf.String())
+		}
+	}
+}
+
+func TestFinallyAfterTermination(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Halt = true
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddGroups(
+		InputGroup{CommandLines: []string{"sleep 10", "echo skipped"}, Finally: []string{"sleep 0.1", "echo cleanup"}},
+		InputGroup{CommandLines: []string{"sleep 0.1", "exit 3"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 3 {
+		t.Errorf("expected exit code 3 but got %d", exitCode)
+	}
+	if buf.String() != "cleanup\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
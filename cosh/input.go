@@ -0,0 +1,399 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// InputError is returned when the structure of the input is not valid.
+type InputError struct {
+	Line int
+	Msg  string
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// InputConfig controls how command lines read from the input are grouped.
+type InputConfig struct {
+	// SequenceLength is the number of consecutive command lines forming a group
+	SequenceLength int
+	// Paragraphs makes each block of consecutive non-blank lines a group, instead of
+	// using SequenceLength
+	Paragraphs bool
+	// GroupSeparator, if not empty, is a line which ends the current group, like a blank line
+	// with Paragraphs
+	GroupSeparator string
+	// Labels enables "label: command" lines; all lines with the same label form a group
+	Labels bool
+	// Mode is the mode of groups, unless specified by a block
+	Mode GroupMode
+	// Finally is a command line always run after each group, unless a block specifies its own
+	Finally string
+}
+
+// DefaultInputConfig is the default input configuration, with each command line in its own group.
+var DefaultInputConfig = InputConfig{
+	SequenceLength: 1,
+}
+
+// InputGroup is a group of command lines read from the input, to be run in sequence.
+type InputGroup struct {
+	// Name is the label of the group, if any
+	Name         string
+	CommandLines []string
+	Mode         GroupMode
+	// Finally are the command lines always run after the group, even if it failed or was terminated
+	Finally []string
+}
+
+// JobSpec returns the specification of the job corresponding to the group.
+func (g InputGroup) JobSpec() JobSpec {
+	return JobSpec{
+		Name:     g.Name,
+		Commands: g.CommandLines,
+		Mode:     g.Mode,
+		Finally:  g.Finally,
+	}
+}
+
+// ReadGroups reads command lines from r and returns them grouped in sequences.
+//
+// Lines ending with an unquoted backslash continue on the next line, while blank lines
+// and lines starting with '#' are ignored. Lines between a line with only '{' and a line
+// with only '}' form a single group, and so do lines joined by a trailing '&&'; all other lines
+// are grouped by sequence length or, with Paragraphs or GroupSeparator, by blank or separator lines.
+// The opening line of a block can specify the mode of the group, e.g. "{ ||", and lines with
+// a "finally:" prefix within the block specify its finally command lines.
+// With Labels, lines starting with the same "label:" prefix form a group, which is placed
+// where the label is first found; the first line of a label also ends the pending unlabeled lines.
+func ReadGroups(r io.Reader, ic InputConfig) ([]InputGroup, error) {
+	if ic.SequenceLength < 1 {
+		return nil, fmt.Errorf("sequence length must be at least 1")
+	}
+	// explicit is true when groups of unlabeled lines are delimited by blank or separator lines
+	explicit := ic.Paragraphs || ic.GroupSeparator != ""
+
+	var (
+		groups []InputGroup
+		labels = map[string]int{}
+		// pending are the unlabeled command lines outside of blocks not yet grouped
+		pending     []string
+		pendingLine int
+		// block is the index of the group of the current block, or -1 outside of blocks
+		block               = -1
+		blockLine, blockLen int
+		blockFinally        bool
+		// chainLine is the line of the last trailing '&&', or 0 if the last command line had none;
+		// chained is the group continued by it, or -1 for pending lines
+		chainLine int
+		chained   = -1
+		lineNo    int
+	)
+	var finally []string
+	if ic.Finally != "" {
+		finally = []string{ic.Finally}
+	}
+	newGroup := func(name string, commandLines []string) {
+		groups = append(groups, InputGroup{
+			Name:         name,
+			CommandLines: commandLines,
+			Mode:         ic.Mode,
+			Finally:      finally,
+		})
+	}
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if explicit {
+			newGroup("", pending)
+		} else {
+			if len(pending)%ic.SequenceLength != 0 {
+				return &InputError{pendingLine, fmt.Sprintf("%d commands are not a multiple of sequence length %d", len(pending), ic.SequenceLength)}
+			}
+			for i := 0; i < len(pending); i += ic.SequenceLength {
+				newGroup("", pending[i:i+ic.SequenceLength])
+			}
+		}
+		pending = nil
+		return nil
+	}
+	// groupFor returns the index of the group with the specified label, appending a new group
+	// if there is none or if name is empty
+	groupFor := func(name string) int {
+		if i, ok := labels[name]; ok && name != "" {
+			return i
+		}
+		newGroup(name, nil)
+		if name != "" {
+			labels[name] = len(groups) - 1
+		}
+		return len(groups) - 1
+	}
+
+	reader := bufio.NewReader(r)
+	readLine := func() (string, bool, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line == "" {
+				return "", false, nil
+			}
+			err = nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		lineNo++
+		return strings.TrimSuffix(line, "\n"), true, nil
+	}
+
+	for {
+		line, ok, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		start := lineNo
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		for {
+			_, _, escaped := scanLine(line)
+			if !escaped {
+				break
+			}
+			next, ok, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, &InputError{start, "unterminated line continuation"}
+			}
+			line = line[:len(line)-1] + next
+		}
+		trimmed = strings.TrimSpace(line)
+
+		if trimmed == "" || (ic.GroupSeparator != "" && trimmed == ic.GroupSeparator) {
+			if block != -1 || (trimmed == "" && !ic.Paragraphs) {
+				continue
+			}
+			if chainLine != 0 {
+				return nil, &InputError{chainLine, "'&&' at the end of a group"}
+			}
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var name string
+		if ic.Labels && block == -1 {
+			if label, rest, ok := splitLabel(line); ok {
+				name, line, trimmed = label, rest, strings.TrimSpace(rest)
+			}
+		}
+
+		mode, isBlock := blockMode(trimmed)
+		switch {
+		case isBlock:
+			if block != -1 {
+				return nil, &InputError{start, "nested blocks are not supported"}
+			}
+			if chainLine != 0 {
+				return nil, &InputError{chainLine, "'&&' cannot be followed by a block"}
+			}
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			block, blockLine, blockFinally = groupFor(name), start, false
+			blockLen = len(groups[block].CommandLines)
+			if mode != nil {
+				groups[block].Mode = *mode
+			}
+			continue
+		case trimmed == "}":
+			if block == -1 {
+				return nil, &InputError{start, "unexpected '}' outside of a block"}
+			}
+			if len(groups[block].CommandLines) == blockLen {
+				return nil, &InputError{blockLine, "empty block"}
+			}
+			block = -1
+			continue
+		case block != -1 && strings.HasPrefix(trimmed, "finally:"):
+			if !blockFinally {
+				// replaces the default finally command
+				groups[block].Finally, blockFinally = nil, true
+			}
+			groups[block].Finally = append(groups[block].Finally, strings.TrimSpace(strings.TrimPrefix(trimmed, "finally:")))
+			continue
+		case block != -1:
+			// all lines of a block are already run in sequence
+			line, _ = trimAnd(line)
+			groups[block].CommandLines = append(groups[block].CommandLines, strings.TrimLeft(line, " \t"))
+			continue
+		}
+
+		line, and := trimAnd(line)
+		switch {
+		case chainLine != 0 && chained != -1:
+			if name != "" && name != groups[chained].Name {
+				return nil, &InputError{start, fmt.Sprintf("label '%s' does not match the group continued by '&&' at line %d", name, chainLine)}
+			}
+			groups[chained].CommandLines = append(groups[chained].CommandLines, strings.TrimLeft(line, " \t"))
+		case name != "":
+			if _, ok := labels[name]; !ok {
+				// keep groups in the same order as the input
+				err := flush()
+				if err != nil {
+					return nil, err
+				}
+			}
+			chained = groupFor(name)
+			groups[chained].CommandLines = append(groups[chained].CommandLines, line)
+		case chainLine != 0 || explicit:
+			if len(pending) == 0 {
+				pendingLine = start
+			}
+			pending = append(pending, line)
+			chained = -1
+		case and:
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			chained = groupFor("")
+			groups[chained].Mode = GroupAnd
+			groups[chained].CommandLines = append(groups[chained].CommandLines, line)
+		default:
+			if len(pending) == 0 {
+				pendingLine = start
+			}
+			pending = append(pending, line)
+		}
+		chainLine = 0
+		if and {
+			chainLine = start
+		}
+	}
+
+	if block != -1 {
+		return nil, &InputError{blockLine, "unterminated block"}
+	}
+	if chainLine != 0 {
+		return nil, &InputError{chainLine, "'&&' at the end of a group"}
+	}
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// blockMode returns true if the line opens a block, i.e. it is '{' optionally followed by
+// a group mode, which is returned if specified.
+func blockMode(line string) (*GroupMode, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	rest := strings.TrimSpace(line[1:])
+	if rest == "" {
+		return nil, true
+	}
+	mode, err := ParseGroupMode(rest)
+	if err != nil {
+		// e.g. a compound command
+		return nil, false
+	}
+	return &mode, true
+}
+
+// scanLine returns the length of the line before its comment, if any, whether the line ends
+// within quotes and whether it ends with a backslash which escapes the newline.
+func scanLine(line string) (int, bool, bool) {
+	// quote is the current quoting character; '$' is used for $'...' strings
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			if i == len(line)-1 {
+				return len(line), quote != 0, quote != '$'
+			}
+			i++
+		case quote == '"' && c == '"', quote == '$' && c == '\'':
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$' && strings.HasPrefix(line[i+1:], "'"):
+			quote = '$'
+			i++
+		case c == '#' && (i == 0 || strings.IndexByte(splitChars+operatorChars, line[i-1]) != -1):
+			return i, false, false
+		}
+	}
+	return len(line), quote != 0, false
+}
+
+// trimAnd removes from the line a trailing '&&' which is neither quoted nor part of a comment;
+// true is returned if it was found.
+func trimAnd(line string) (string, bool) {
+	code, quoted, _ := scanLine(line)
+	s := strings.TrimRight(line[:code], " \t")
+	if quoted || !strings.HasSuffix(s, "&&") {
+		return line, false
+	}
+	return strings.TrimRight(s[:len(s)-2], " \t"), true
+}
+
+// splitLabel splits a "label: command" line; labels are made of letters, digits, '_', '-' and '.'.
+func splitLabel(line string) (string, string, bool) {
+	s := strings.TrimLeft(line, " \t")
+	n := 0
+	for n < len(s) && (isNameChar(s[n], false) || s[n] == '-' || s[n] == '.') {
+		n++
+	}
+	if n == 0 || !strings.HasPrefix(s[n:], ":") {
+		return "", "", false
+	}
+	rest := s[n+1:]
+	if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", "", false
+	}
+	return s[:n], strings.TrimLeft(rest, " \t"), true
+}
@@ -0,0 +1,129 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadGroups(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		ic       InputConfig
+		expected [][]string
+	}{
+		{"a\n\nb\n", DefaultInputConfig, [][]string{{"a"}, {"b"}}},
+		{"a\nb\nc\nd", InputConfig{SequenceLength: 2}, [][]string{{"a", "b"}, {"c", "d"}}},
+		{"# comment\na \\\n  b\n  # indented comment\nc", DefaultInputConfig, [][]string{{"a   b"}, {"c"}}},
+		{"echo 'a\\'\necho \"b\\\nc\"\n", DefaultInputConfig, [][]string{{"echo 'a\\'"}, {"echo \"bc\""}}},
+		{"echo a # b \\\nc", DefaultInputConfig, [][]string{{"echo a # b \\"}, {"c"}}},
+		{"a\n{\n  b\n\n  c\n}\nd", DefaultInputConfig, [][]string{{"a"}, {"b", "c"}, {"d"}}},
+		{"a\nb\n\n\nc\n{\nd\n}\n", InputConfig{SequenceLength: 1, Paragraphs: true}, [][]string{{"a", "b"}, {"c"}, {"d"}}},
+		{"a\nb\n---\n\nc\n", InputConfig{SequenceLength: 1, GroupSeparator: "---"}, [][]string{{"a", "b"}, {"c"}}},
+		{"a\nb &&\n  c && # comment\nd\ne '&&'\nf", DefaultInputConfig, [][]string{{"a"}, {"b", "c", "d"}, {"e '&&'"}, {"f"}}},
+		{"{ ||\na\nfinally: b\n}\n{ echo; }\n", DefaultInputConfig, [][]string{{"a"}, {"{ echo; }"}}},
+		{"x: a\nb\ny: c &&\nd\nx: e\nurl: http://host\n", InputConfig{SequenceLength: 1, Labels: true}, [][]string{{"a", "e"}, {"b"}, {"c", "d"}, {"http://host"}}},
+	} {
+		groups, err := ReadGroups(strings.NewReader(tc.input), tc.ic)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		var lines [][]string
+		for _, g := range groups {
+			lines = append(lines, g.CommandLines)
+		}
+		if !reflect.DeepEqual(lines, tc.expected) {
+			t.Errorf("%q: expected %q but got %q", tc.input, tc.expected, lines)
+		}
+	}
+
+	groups, err := ReadGroups(strings.NewReader("a\n{ ;\nb\nfinally: c\nfinally: d\n}\n"), InputConfig{SequenceLength: 1, Mode: GroupOr, Finally: "e"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if groups[0].Mode != GroupOr || !reflect.DeepEqual(groups[0].Finally, []string{"e"}) {
+		t.Errorf("unexpected defaults: %+v", groups[0])
+	}
+	if groups[1].Mode != GroupAll || !reflect.DeepEqual(groups[1].Finally, []string{"c", "d"}) {
+		t.Errorf("unexpected block settings: %+v", groups[1])
+	}
+
+	for _, tc := range []struct {
+		input string
+		ic    InputConfig
+		line  int
+	}{
+		{"a\nb\nc", InputConfig{SequenceLength: 2}, 1},
+		{"a\n{\nb\n{\n}\n}", DefaultInputConfig, 4},
+		{"a\n{\nb\n", DefaultInputConfig, 2},
+		{"a\n}\n", DefaultInputConfig, 2},
+		{"{\n}\n", DefaultInputConfig, 1},
+		{"a\nb \\", DefaultInputConfig, 2},
+		{"a &&\n", DefaultInputConfig, 1},
+		{"a &&\n---\nb", InputConfig{SequenceLength: 1, GroupSeparator: "---"}, 1},
+		{"a &&\n{\nb\n}", DefaultInputConfig, 1},
+		{"x: a &&\ny: b", InputConfig{SequenceLength: 1, Labels: true}, 2},
+	} {
+		_, err := ReadGroups(strings.NewReader(tc.input), tc.ic)
+		ie, ok := err.(*InputError)
+		if !ok {
+			t.Errorf("%q: expected input error but got %v", tc.input, err)
+			continue
+		}
+		if ie.Line != tc.line {
+			t.Errorf("%q: expected error at line %d but got %v", tc.input, tc.line, err)
+		}
+	}
+}
+
+func TestAddGroups(t *testing.T) {
+	var buf bytes.Buffer
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddGroups(
+		InputGroup{Name: "one", CommandLines: []string{"echo a"}},
+		InputGroup{CommandLines: []string{"echo b", "echo c", "false", "echo d"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 1 {
+		t.Errorf("expected exit code 1 but got %d", exitCode)
+	}
+	if buf.String() != "a\nb\nc\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// JobSpec is the specification of a job, which runs as a command group.
+type JobSpec struct {
+	// Name identifies the job in dependencies and messages; it is optional, but must be unique
+	Name string
+	// Commands are the command lines run in sequence according to Mode
+	Commands []string
+	Mode     GroupMode
+	// Finally are the command lines always run after the job, even if it failed or was terminated
+	Finally []string
+	// Timeout, if not zero, is the maximum duration of the job, including retries; a job which
+	// times out is terminated and its exit code is 124
+	Timeout time.Duration
+	// Env are additional environment variables in the NAME=value form
+	Env []string
+	// Dir is the working directory; relative paths are relative to the current working directory
+	Dir string
+	// Retries is the number of times the job is run again after failing
+	Retries int
+	// Restart, if not nil, controls restarts of the job: its policy and its non-zero fields replace
+	// those of the command pool
+	Restart *RestartConfig
+	// Ready, if not nil, is the readiness probe of the job
+	Ready *ReadyProbe
+	// StopSignal and StopGrace, if not zero, override TermSignal and KillAfter of the command pool
+	StopSignal syscall.Signal
+	StopGrace  time.Duration
+	// StopPriority orders termination: jobs with a lower priority are stopped first, and
+	// jobs are always stopped after the jobs depending on them
+	StopPriority int
+	// Nice and IONice, if not nil, override Nice and IONice of the command pool, even with
+	// zero values, e.g. to run the job with the default priority
+	Nice   *int
+	IONice *IOPriority
+	// Rlimits are resource limits replacing those of the command pool for the same resources
+	Rlimits []Rlimit
+	// CgroupLimits replace the non-zero limits of the command pool; a job with any limit is
+	// placed in its own cgroup
+	CgroupLimits CgroupLimits
+	// After are the names of the jobs which must complete successfully before the job starts
+	After []string
+	// Tags are arbitrary labels of the job, used to select jobs with FilterJobs
+	Tags []string
+}
+
+// timeoutExitCode is the exit code of jobs which timed out, like timeout(1).
+const timeoutExitCode = 124
+
+// AddJobs will add a command group for each of the specified jobs.
+func (cp *CommandPool) AddJobs(jobs ...JobSpec) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	env := os.Environ()
+
+	names := map[string]bool{}
+	for _, cg := range cp.groups {
+		names[cg.name] = true
+	}
+
+	for _, job := range jobs {
+		if len(job.Commands) == 0 {
+			return ErrEmptyCommandLine
+		}
+		if job.Name != "" {
+			if names[job.Name] {
+				return fmt.Errorf("duplicate job name %q", job.Name)
+			}
+			names[job.Name] = true
+		}
+		if job.Retries < 0 {
+			return fmt.Errorf("job %q: invalid number of retries %d", job.Name, job.Retries)
+		}
+
+		dir := cwd
+		if job.Dir != "" {
+			dir = job.Dir
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(cwd, dir)
+			}
+		}
+		jobEnv := env
+		if len(job.Env) != 0 {
+			jobEnv = append(env[:len(env):len(env)], job.Env...)
+		}
+
+		stdin, err := cp.stdinFor(len(cp.groups))
+		if err != nil {
+			return err
+		}
+		err = cp.addGroup(dir, jobEnv, stdin, job.Commands)
+		if err != nil {
+			return err
+		}
+		cg := cp.groups[len(cp.groups)-1]
+		cg.name, cg.mode = job.Name, job.Mode
+		cg.timeout, cg.retries = job.Timeout, job.Retries
+		if job.Restart != nil {
+			cg.restart = cg.restart.merge(*job.Restart)
+		}
+		cg.after, cg.tags = job.After, job.Tags
+		if job.StopSignal != 0 {
+			cg.stopSignal = job.StopSignal
+		}
+		if job.StopGrace != 0 {
+			cg.stopGrace = job.StopGrace
+		}
+		cg.stopPriority = job.StopPriority
+		if job.Nice != nil {
+			cg.nice = *job.Nice
+		}
+		if job.IONice != nil {
+			cg.ionice = *job.IONice
+		}
+		cg.rlimits = mergeRlimits(cg.rlimits, job.Rlimits)
+		cg.cgroupLimits = cg.cgroupLimits.merge(job.CgroupLimits)
+		err = cg.setFinally(cp, job.Finally)
+		if err != nil {
+			return err
+		}
+		if job.Ready != nil {
+			err = cg.setReadyProbe(cp, *job.Ready)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dependencies returns, for each command group, the indexes of the groups it depends on;
+// an error is returned for unknown names and dependency cycles.
+func (cp *CommandPool) dependencies() ([][]int, error) {
+	indexes := map[string]int{}
+	for i, cg := range cp.groups {
+		if cg.name != "" {
+			indexes[cg.name] = i
+		}
+	}
+
+	deps := make([][]int, len(cp.groups))
+	for i, cg := range cp.groups {
+		for _, name := range cg.after {
+			d, ok := indexes[name]
+			if !ok {
+				return nil, fmt.Errorf("%s depends on unknown job %q", cg.displayName(), name)
+			}
+			deps[i] = append(deps[i], d)
+		}
+	}
+
+	// depth-first search of cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(cp.groups))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("dependency cycle involving %s", cp.groups[i].displayName())
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, d := range deps[i] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range cp.groups {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps, nil
+}
+
+// FilterJobs returns the jobs with any of the specified tags, or named like any of them, together
+// with all the jobs they depend on, in their original order.
+func FilterJobs(jobs []JobSpec, tags []string) []JobSpec {
+	wanted := map[string]bool{}
+	for _, tag := range tags {
+		wanted[tag] = true
+	}
+	byName := map[string]int{}
+	for i, job := range jobs {
+		if job.Name != "" {
+			byName[job.Name] = i
+		}
+	}
+
+	selected := make([]bool, len(jobs))
+	var sel func(i int)
+	sel = func(i int) {
+		if selected[i] {
+			return
+		}
+		selected[i] = true
+		for _, name := range jobs[i].After {
+			if d, ok := byName[name]; ok {
+				sel(d)
+			}
+		}
+	}
+	for i, job := range jobs {
+		// the name of a job is a tag of its own
+		if job.Name != "" && wanted[job.Name] {
+			sel(i)
+			continue
+		}
+		for _, tag := range job.Tags {
+			if wanted[tag] {
+				sel(i)
+				break
+			}
+		}
+	}
+
+	var r []JobSpec
+	for i, job := range jobs {
+		if selected[i] {
+			r = append(r, job)
+		}
+	}
+	return r
+}
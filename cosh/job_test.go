@@ -0,0 +1,244 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runJobs(t *testing.T, jobs ...JobSpec) (int, string) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(jobs...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return exitCode, buf.String()
+}
+
+func TestJobDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-job")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	exitCode, output := runJobs(t,
+		JobSpec{Name: "c", Commands: []string{"cat b.out"}, After: []string{"b"}, Dir: dir},
+		JobSpec{Name: "b", Commands: []string{"sleep 0.1", "echo b > b.out"}, After: []string{"a"}, Dir: dir},
+		JobSpec{Name: "a", Commands: []string{"sleep 0.2", "echo a"}},
+		JobSpec{Name: "failed", Commands: []string{"exit 3"}},
+		JobSpec{Name: "skipped", Commands: []string{"echo skipped"}, After: []string{"a", "failed"}},
+	)
+	if exitCode != 4 {
+		t.Errorf("expected exit code 4 but got %d", exitCode)
+	}
+	// c reads the file written by b
+	if output != "b\na\ncoshell: skipped not started because failed failed\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	for _, jobs := range [][]JobSpec{
+		{{Name: "a", Commands: []string{"true"}, After: []string{"b"}}},
+		{{Name: "a", Commands: []string{"true"}, After: []string{"b"}}, {Name: "b", Commands: []string{"true"}, After: []string{"a"}}},
+	} {
+		cp := NewCommandPool(nil)
+		err = cp.AddJobs(jobs...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if cp.Start(0) == nil {
+			t.Errorf("%+v: expected error", jobs)
+		}
+	}
+
+	cp := NewCommandPool(nil)
+	if cp.AddJobs(JobSpec{Name: "a", Commands: []string{"true"}}, JobSpec{Name: "a", Commands: []string{"true"}}) == nil {
+		t.Error("expected error for duplicate names")
+	}
+}
+
+func TestJobOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-job")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	exitCode, output := runJobs(t, JobSpec{
+		Commands: []string{"sh -c 'echo $A $B $PWD'"},
+		Env:      []string{"A=1", "B=2"},
+		Dir:      dir,
+	})
+	if exitCode != 0 || output != "1 2 "+dir+"\n" {
+		t.Errorf("unexpected result: %d %q", exitCode, output)
+	}
+
+	exitCode, output = runJobs(t, JobSpec{
+		Name:     "retried",
+		Commands: []string{"cd /", "pwd", "exit 2"},
+		Retries:  1,
+	})
+	if exitCode != 2 || output != "/\ncoshell: retried failed with exit code 2, retrying (1/1)\n/\n" {
+		t.Errorf("unexpected result: %d %q", exitCode, output)
+	}
+
+	start := time.Now()
+	exitCode, output = runJobs(t, JobSpec{
+		Commands: []string{"sleep 10"},
+		Timeout:  100 * time.Millisecond,
+		Retries:  3,
+	})
+	if exitCode != timeoutExitCode || !strings.Contains(output, "timed out") {
+		t.Errorf("unexpected result: %d %q", exitCode, output)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("job was not terminated")
+	}
+}
+
+func TestJobStdin(t *testing.T) {
+	f, err := ioutil.TempFile("", "coshell-stdin")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("x\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = ioutil.Discard
+	cfg.Stdin, cfg.StdinPath = StdinFile, f.Name()
+
+	// each attempt and the finally commands read the whole input
+	cp := NewCommandPool(&cfg)
+	err = cp.AddJobs(JobSpec{
+		Commands: []string{"cat", "exit 1"},
+		Retries:  1,
+		Finally:  []string{"cat"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 1 || buf.String() != "x\nx\nx\n" {
+		t.Errorf("unexpected result: %d %q", exitCode, buf.String())
+	}
+}
+
+func TestFilterJobs(t *testing.T) {
+	jobs := []JobSpec{
+		{Name: "a"},
+		{Name: "b", After: []string{"a"}},
+		{Name: "c", Tags: []string{"x"}, After: []string{"b"}},
+		{Name: "d", Tags: []string{"y"}},
+		{Name: "e", Tags: []string{"z", "y"}},
+	}
+	var names []string
+	for _, job := range FilterJobs(jobs, []string{"x", "z"}) {
+		names = append(names, job.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b", "c", "e"}) {
+		t.Errorf("unexpected jobs: %v", names)
+	}
+
+	names = nil
+	for _, job := range FilterJobs(jobs, []string{"b", "d"}) {
+		names = append(names, job.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b", "d"}) {
+		t.Errorf("unexpected jobs: %v", names)
+	}
+}
+
+func TestMasterByName(t *testing.T) {
+	for _, master := range []string{"main", "1"} {
+		cfg := DefaultCommandPoolConfig
+		cfg.Masters = []string{master}
+
+		cp := NewCommandPool(&cfg)
+		err := cp.AddJobs(
+			JobSpec{Name: "background", Commands: []string{"sleep 10"}},
+			JobSpec{Name: "main", Commands: []string{"sleep 0.1", "exit 5"}},
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		start := time.Now()
+		err = cp.Start(0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		exitCode, err := cp.Join()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if exitCode != 5 {
+			t.Errorf("%s: expected exit code 5 but got %d", master, exitCode)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: background job was not terminated", master)
+		}
+	}
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Masters = []string{"unknown"}
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(JobSpec{Name: "a", Commands: []string{"true"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cp.Start(0) == nil {
+		t.Error("expected error for unknown master")
+	}
+}
@@ -0,0 +1,440 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadManifest reads job specifications from a manifest, either in JSON or in the line-oriented
+// format, where each job is a section named after the job followed by "key = value" lines:
+//
+//	# comment
+//	[build]
+//	command = make
+//	command = make install
+//	timeout = 10m
+//	env = CFLAGS=-O2
+//	dir = src
+//	retries = 2
+//	restart = on-failure
+//	max-restarts = 5
+//	restart-window = 1m
+//	restart-backoff = 1s
+//	ready = tcp:8080
+//	stop-signal = TERM
+//	stop-grace = 30s
+//	stop-priority = 1
+//	nice = 10
+//	ionice = best-effort:7
+//	ulimit = nofile=1024, as=4G
+//	memory-max = 2G
+//	cpu-max = 1.5
+//	pids-max = 100
+//	after = fetch, configure
+//	tags = ci
+//
+// The command, finally, env, ulimit, after and tags keys can be repeated; after and tags take lists
+// separated by commas or spaces. Other keys are mode, as accepted by ParseGroupMode, restart,
+// as accepted by ParseRestartPolicy, and timeout, restart-window and restart-backoff, as accepted
+// by time.ParseDuration, ready, as accepted by ParseReadyProbe, stop-signal, as accepted by
+// ParseSignal, stop-grace, also a duration, stop-priority, an integer, nice, as accepted by ParseNice,
+// ionice, as accepted by ParseIOPriority, ulimit, as accepted by ParseRlimits, memory-max, a size
+// as accepted by ParseSize, cpu-max, as accepted by ParseCPUMax, and pids-max, as accepted by
+// ParsePidsMax. The other restart keys require restart, and those not specified are those of
+// the command pool.
+//
+// A JSON manifest is an array of objects, or an object with such an array as "jobs", with the
+// same keys plus "commands" for lists of commands and objects for "env" and "ulimit", e.g.
+// {"nofile": "1024"}.
+func ReadManifest(r io.Reader) ([]JobSpec, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isJSONManifest(data) {
+		return readJSONManifest(data)
+	}
+
+	var (
+		jobs   []JobSpec
+		job    *JobSpec
+		lineNo int
+		// policies are the jobs with a restart policy
+		policies = map[int]bool{}
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, &InputError{lineNo, "unterminated section name"}
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, &InputError{lineNo, "empty job name"}
+			}
+			jobs = append(jobs, JobSpec{Name: name})
+			job = &jobs[len(jobs)-1]
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return nil, &InputError{lineNo, "expected 'key = value'"}
+		}
+		if job == nil {
+			return nil, &InputError{lineNo, "key outside of a job section"}
+		}
+		key, value := strings.TrimSpace(line[:eq]), strings.TrimSpace(line[eq+1:])
+
+		err := job.set(key, value)
+		if err != nil {
+			return nil, &InputError{lineNo, err.Error()}
+		}
+		if key == "restart" {
+			policies[len(jobs)-1] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, job := range jobs {
+		if len(job.Commands) == 0 {
+			return nil, fmt.Errorf("job %q has no commands", job.Name)
+		}
+		if job.Restart != nil && !policies[i] {
+			return nil, fmt.Errorf("job %q has restart options but no restart policy", job.Name)
+		}
+	}
+
+	return jobs, nil
+}
+
+// set sets a field of the job from a manifest key and value.
+func (job *JobSpec) set(key, value string) error {
+	var err error
+	if strings.HasPrefix(key, "restart") || key == "max-restarts" {
+		if job.Restart == nil {
+			job.Restart = &RestartConfig{}
+		}
+	}
+	switch key {
+	case "command":
+		job.Commands = append(job.Commands, value)
+	case "finally":
+		job.Finally = append(job.Finally, value)
+	case "mode":
+		job.Mode, err = ParseGroupMode(value)
+	case "timeout":
+		job.Timeout, err = time.ParseDuration(value)
+	case "env":
+		if !strings.Contains(value, "=") || !isName(value[:strings.IndexByte(value, '=')]) {
+			return fmt.Errorf("invalid environment variable %q", value)
+		}
+		job.Env = append(job.Env, value)
+	case "dir":
+		job.Dir = value
+	case "retries":
+		job.Retries, err = strconv.Atoi(value)
+		if err == nil && job.Retries < 0 {
+			err = fmt.Errorf("invalid number of retries %d", job.Retries)
+		}
+	case "restart":
+		job.Restart.Policy, err = ParseRestartPolicy(value)
+	case "max-restarts":
+		job.Restart.MaxRestarts, err = strconv.Atoi(value)
+		if err == nil && job.Restart.MaxRestarts < 0 {
+			err = fmt.Errorf("invalid number of restarts %d", job.Restart.MaxRestarts)
+		}
+	case "restart-window":
+		job.Restart.Window, err = time.ParseDuration(value)
+	case "restart-backoff":
+		job.Restart.Backoff, err = time.ParseDuration(value)
+	case "ready":
+		job.Ready, err = ParseReadyProbe(value)
+	case "stop-signal":
+		job.StopSignal, err = ParseSignal(value)
+	case "stop-grace":
+		job.StopGrace, err = time.ParseDuration(value)
+	case "stop-priority":
+		job.StopPriority, err = strconv.Atoi(value)
+	case "nice":
+		var nice int
+		nice, err = ParseNice(value)
+		job.Nice = &nice
+	case "ionice":
+		var ionice IOPriority
+		ionice, err = ParseIOPriority(value)
+		job.IONice = &ionice
+	case "ulimit":
+		var limits []Rlimit
+		limits, err = ParseRlimits(value)
+		job.Rlimits = mergeRlimits(job.Rlimits, limits)
+	case "memory-max":
+		job.CgroupLimits.MemoryMax, err = ParseSize(value)
+		if err == nil && job.CgroupLimits.MemoryMax == 0 {
+			err = fmt.Errorf("invalid memory limit %q", value)
+		}
+	case "cpu-max":
+		job.CgroupLimits.CPUMax, err = ParseCPUMax(value)
+	case "pids-max":
+		job.CgroupLimits.PidsMax, err = ParsePidsMax(value)
+	case "after":
+		job.After = append(job.After, splitList(value)...)
+	case "tags":
+		job.Tags = append(job.Tags, splitList(value)...)
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return err
+}
+
+// splitList splits a list of values separated by commas or spaces.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t'
+	})
+}
+
+// isJSONManifest returns true if data is a JSON object or array of objects, rather than
+// a manifest in the line-oriented format.
+func isJSONManifest(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		return true
+	}
+	if !bytes.HasPrefix(data, []byte("[")) {
+		return false
+	}
+	data = bytes.TrimSpace(data[1:])
+	return bytes.HasPrefix(data, []byte("{")) || bytes.HasPrefix(data, []byte("]"))
+}
+
+// jsonJob is a job in a JSON manifest.
+type jsonJob struct {
+	Name     string            `json:"name"`
+	Command  string            `json:"command"`
+	Commands []string          `json:"commands"`
+	Mode     string            `json:"mode"`
+	Finally  []string          `json:"finally"`
+	Timeout  string            `json:"timeout"`
+	Env      map[string]string `json:"env"`
+	Dir      string            `json:"dir"`
+	Retries  int               `json:"retries"`
+	After    []string          `json:"after"`
+	Tags     []string          `json:"tags"`
+
+	Restart        string `json:"restart"`
+	MaxRestarts    int    `json:"max-restarts"`
+	RestartWindow  string `json:"restart-window"`
+	RestartBackoff string `json:"restart-backoff"`
+	Ready          string `json:"ready"`
+	StopSignal     string `json:"stop-signal"`
+	StopGrace      string `json:"stop-grace"`
+	StopPriority   int    `json:"stop-priority"`
+	Nice           *int   `json:"nice"`
+	IONice         string `json:"ionice"`
+
+	Ulimit    map[string]string `json:"ulimit"`
+	MemoryMax string            `json:"memory-max"`
+	CPUMax    float64           `json:"cpu-max"`
+	PidsMax   int64             `json:"pids-max"`
+}
+
+func readJSONManifest(data []byte) ([]JobSpec, error) {
+	var list []jsonJob
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var m struct {
+			Jobs []jsonJob `json:"jobs"`
+		}
+		err := unmarshalStrict(data, &m)
+		if err != nil {
+			return nil, err
+		}
+		list = m.Jobs
+	} else {
+		err := unmarshalStrict(data, &list)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	jobs := make([]JobSpec, len(list))
+	for i, j := range list {
+		job := JobSpec{
+			Name:         j.Name,
+			Commands:     j.Commands,
+			Finally:      j.Finally,
+			Dir:          j.Dir,
+			Retries:      j.Retries,
+			StopPriority: j.StopPriority,
+			After:        j.After,
+			Tags:         j.Tags,
+		}
+		if j.Command != "" {
+			job.Commands = append([]string{j.Command}, job.Commands...)
+		}
+		if len(job.Commands) == 0 {
+			return nil, fmt.Errorf("job #%d %q has no commands", i, j.Name)
+		}
+		if j.Retries < 0 {
+			return nil, fmt.Errorf("job #%d %q: invalid number of retries %d", i, j.Name, j.Retries)
+		}
+
+		var err error
+		if j.Mode != "" {
+			job.Mode, err = ParseGroupMode(j.Mode)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+		if j.Timeout != "" {
+			job.Timeout, err = time.ParseDuration(j.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+
+		if j.Restart != "" || j.MaxRestarts != 0 || j.RestartWindow != "" || j.RestartBackoff != "" {
+			if j.Restart == "" {
+				return nil, fmt.Errorf("job #%d %q: restart options but no restart policy", i, j.Name)
+			}
+			job.Restart = &RestartConfig{MaxRestarts: j.MaxRestarts}
+			if j.MaxRestarts < 0 {
+				return nil, fmt.Errorf("job #%d %q: invalid number of restarts %d", i, j.Name, j.MaxRestarts)
+			}
+			job.Restart.Policy, err = ParseRestartPolicy(j.Restart)
+			if err == nil && j.RestartWindow != "" {
+				job.Restart.Window, err = time.ParseDuration(j.RestartWindow)
+			}
+			if err == nil && j.RestartBackoff != "" {
+				job.Restart.Backoff, err = time.ParseDuration(j.RestartBackoff)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+
+		if j.StopSignal != "" {
+			job.StopSignal, err = ParseSignal(j.StopSignal)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+		if j.StopGrace != "" {
+			job.StopGrace, err = time.ParseDuration(j.StopGrace)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+		if j.Ready != "" {
+			job.Ready, err = ParseReadyProbe(j.Ready)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+		if j.Nice != nil {
+			var nice int
+			nice, err = ParseNice(strconv.Itoa(*j.Nice))
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+			job.Nice = &nice
+		}
+		if j.IONice != "" {
+			var ionice IOPriority
+			ionice, err = ParseIOPriority(j.IONice)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+			job.IONice = &ionice
+		}
+
+		if j.MemoryMax != "" {
+			job.CgroupLimits.MemoryMax, err = ParseSize(j.MemoryMax)
+			if err == nil && job.CgroupLimits.MemoryMax == 0 {
+				err = fmt.Errorf("invalid memory limit %q", j.MemoryMax)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+		if j.CPUMax != 0 {
+			job.CgroupLimits.CPUMax, err = ParseCPUMax(strconv.FormatFloat(j.CPUMax, 'g', -1, 64))
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+		if j.PidsMax != 0 {
+			job.CgroupLimits.PidsMax, err = ParsePidsMax(strconv.FormatInt(j.PidsMax, 10))
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+		}
+
+		for name, value := range j.Ulimit {
+			limit, err := parseRlimit(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("job #%d %q: %v", i, j.Name, err)
+			}
+			job.Rlimits = mergeRlimits(job.Rlimits, []Rlimit{limit})
+		}
+
+		names := make([]string, 0, len(j.Env))
+		for name := range j.Env {
+			if !isName(name) {
+				return nil, fmt.Errorf("job #%d %q: invalid environment variable %q", i, j.Name, name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			job.Env = append(job.Env, name+"="+j.Env[name])
+		}
+
+		jobs[i] = job
+	}
+
+	return jobs, nil
+}
+
+// unmarshalStrict unmarshals JSON data rejecting unknown fields, so that typos are reported.
+func unmarshalStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
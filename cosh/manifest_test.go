@@ -0,0 +1,150 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadManifest(t *testing.T) {
+	nice := 10
+	expected := []JobSpec{
+		{
+			Name:     "fetch",
+			Commands: []string{"git fetch"},
+			Restart:  &RestartConfig{Policy: RestartOnFailure, MaxRestarts: 3, Backoff: time.Second},
+			Ready:    &ReadyProbe{Output: "^done"},
+			Tags:     []string{"ci"},
+		},
+		{
+			Name:         "build",
+			Commands:     []string{"make", "make install"},
+			Mode:         GroupAll,
+			Finally:      []string{"make clean"},
+			Timeout:      10 * time.Minute,
+			Env:          []string{"A=1", "B=x=y"},
+			Dir:          "src",
+			Retries:      2,
+			StopSignal:   syscall.SIGTERM,
+			StopGrace:    time.Minute,
+			StopPriority: 1,
+			Nice:         &nice,
+			IONice:       &IOPriority{Class: IOBestEffort, Level: 7},
+			Rlimits:      []Rlimit{{RlimitAS, 4 << 30, 4 << 30}, {RlimitNOFILE, 1024, 2048}, {RlimitCORE, 0, 0}},
+			CgroupLimits: CgroupLimits{MemoryMax: 2 << 30, CPUMax: 1.5, PidsMax: 100},
+			After:        []string{"fetch", "configure"},
+			Tags:         []string{"ci", "slow"},
+		},
+	}
+
+	for _, input := range []string{`
+# comment
+[fetch]
+command = git fetch
+restart = on-failure
+max-restarts = 3
+restart-backoff = 1s
+ready = output:^done
+tags = ci
+
+; another comment
+[ build ]
+command = make
+command = make install
+mode = ;
+finally = make clean
+timeout = 10m
+env = A=1
+env = B=x=y
+dir = src
+retries = 2
+stop-signal = SIGTERM
+stop-grace = 1m
+stop-priority = 1
+nice = 10
+ionice = best-effort:7
+ulimit = nofile=1024:2048, as=4G
+ulimit = core=0
+memory-max = 2G
+cpu-max = 1.5
+pids-max = 100
+after = fetch, configure
+tags = ci slow
+`, `[
+	{"name": "fetch", "command": "git fetch", "tags": ["ci"], "restart": "on-failure", "max-restarts": 3, "restart-backoff": "1s", "ready": "output:^done"},
+	{"name": "build", "commands": ["make", "make install"], "mode": ";", "finally": ["make clean"],
+	 "timeout": "10m", "env": {"B": "x=y", "A": "1"}, "dir": "src", "retries": 2, "stop-signal": "TERM", "stop-grace": "1m", "stop-priority": 1,
+	 "nice": 10, "ionice": "best-effort:7", "ulimit": {"nofile": "1024:2048", "as": "4G", "core": "0"},
+	 "memory-max": "2G", "cpu-max": 1.5, "pids-max": 100, "after": ["fetch", "configure"], "tags": ["ci", "slow"]}
+]`, `{"jobs": [
+	{"name": "fetch", "command": "git fetch", "tags": ["ci"], "restart": "on-failure", "max-restarts": 3, "restart-backoff": "1s", "ready": "output:^done"},
+	{"name": "build", "command": "make", "commands": ["make install"], "mode": "all", "finally": ["make clean"],
+	 "timeout": "10m", "env": {"A": "1", "B": "x=y"}, "dir": "src", "retries": 2, "stop-signal": "TERM", "stop-grace": "1m", "stop-priority": 1,
+	 "nice": 10, "ionice": "2:7", "ulimit": {"core": "0", "as": "4096M", "nofile": "1024:2048"},
+	 "memory-max": "2048M", "cpu-max": 1.5, "pids-max": 100, "after": ["fetch", "configure"], "tags": ["ci", "slow"]}
+]}`} {
+		jobs, err := ReadManifest(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if !reflect.DeepEqual(jobs, expected) {
+			t.Errorf("%q: expected %+v but got %+v", input, expected, jobs)
+		}
+	}
+
+	for _, input := range []string{
+		"command = x",
+		"[a]\ncommand x",
+		"[a\ncommand = x",
+		"[a]\ncommand = x\nunknown = y",
+		"[a]\ncommand = x\ntimeout = 10",
+		"[a]\ncommand = x\nretries = -1",
+		"[a]\ncommand = x\nrestart = sometimes",
+		"[a]\ncommand = x\nready = port:80",
+		"[a]\ncommand = x\nstop-signal = STOP",
+		"[a]\ncommand = x\nmax-restarts = -1",
+		"[a]\ncommand = x\nrestart-backoff = 1s",
+		"[a]\ncommand = x\nnice = 20",
+		"[a]\ncommand = x\nionice = idle:3",
+		"[a]\ncommand = x\nulimit = files=10",
+		"[a]\ncommand = x\nmemory-max = 0",
+		"[a]\ncommand = x\ncpu-max = 0",
+		"[a]\ncommand = x\npids-max = -1",
+		"[a]\ncommand = x\nenv = 1A=b",
+		"[a]\ntags = x",
+		`[{"name": "a"}]`,
+		`[{"name": "a", "command": "x", "unknown": 1}]`,
+		`[{"name": "a", "command": "x", "mode": "xor"}]`,
+		`[{"name": "a", "command": "x", "nice": -21}]`,
+		`[{"name": "a", "command": "x", "ulimit": {"nofile": "2:1"}}]`,
+		`[{"name": "a", "command": "x", "pids-max": -1}]`,
+		`[{"name": "a", "command": "x", "max-restarts": 2}]`,
+	} {
+		_, err := ReadManifest(strings.NewReader(input))
+		if err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "fmt"
+
+// MasterPolicy specifies when master command groups terminate the command pool.
+type MasterPolicy int
+
+const (
+	// MasterAny terminates all other command groups as soon as any master exits.
+	MasterAny MasterPolicy = iota
+	// MasterAll terminates all other command groups once all masters have exited.
+	MasterAll
+)
+
+// MasterExit specifies which exit code of the master command groups is used.
+type MasterExit int
+
+const (
+	// MasterExitFirst uses the exit code of the first master which exited.
+	MasterExitFirst MasterExit = iota
+	// MasterExitLast uses the exit code of the last master which exited.
+	MasterExitLast
+	// MasterExitWorst uses the highest exit code of the masters; -1, which is used for
+	// terminated command groups, is the worst.
+	MasterExitWorst
+)
+
+// ParseMasterPolicy parses a master policy, either 'any' or 'all'.
+func ParseMasterPolicy(s string) (MasterPolicy, error) {
+	switch s {
+	case "any":
+		return MasterAny, nil
+	case "all":
+		return MasterAll, nil
+	}
+	return MasterAny, fmt.Errorf("invalid master policy: %q", s)
+}
+
+// ParseMasterExit parses the selection of the master exit code, one of 'first', 'last' or 'worst'.
+func ParseMasterExit(s string) (MasterExit, error) {
+	switch s {
+	case "first":
+		return MasterExitFirst, nil
+	case "last":
+		return MasterExitLast, nil
+	case "worst":
+		return MasterExitWorst, nil
+	}
+	return MasterExitFirst, fmt.Errorf("invalid master exit code selection: %q", s)
+}
+
+// selectExitCode returns the exit code selected among those of the masters, in order of exit.
+func (me MasterExit) selectExitCode(exitCodes []int) int {
+	switch me {
+	case MasterExitLast:
+		return exitCodes[len(exitCodes)-1]
+	case MasterExitWorst:
+		worst := exitCodes[0]
+		for _, exitCode := range exitCodes[1:] {
+			if uint(exitCode) > uint(worst) {
+				worst = exitCode
+			}
+		}
+		return worst
+	}
+	return exitCodes[0]
+}
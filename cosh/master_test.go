@@ -0,0 +1,79 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMasterPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy   MasterPolicy
+		exit     MasterExit
+		exitCode int
+	}{
+		{MasterAny, MasterExitFirst, 4},
+		{MasterAny, MasterExitLast, 4},
+		{MasterAll, MasterExitFirst, 4},
+		{MasterAll, MasterExitLast, 3},
+		{MasterAll, MasterExitWorst, 4},
+	} {
+		cfg := DefaultCommandPoolConfig
+		cfg.Masters = []string{"a", "b"}
+		cfg.MasterPolicy = tc.policy
+		cfg.MasterExit = tc.exit
+
+		cp := NewCommandPool(&cfg)
+		err := cp.AddJobs(
+			JobSpec{Name: "sidecar", Commands: []string{"sleep 10"}},
+			JobSpec{Name: "a", Commands: []string{"sleep 0.1", "exit 4"}},
+			JobSpec{Name: "b", Commands: []string{"sleep 0.3", "exit 3"}},
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		start := time.Now()
+		err = cp.Start(0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		exitCode, err := cp.Join()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if exitCode != tc.exitCode {
+			t.Errorf("%+v: expected exit code %d but got %d", tc, tc.exitCode, exitCode)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%+v: sidecar was not terminated", tc)
+		}
+	}
+}
+
+func TestSelectExitCode(t *testing.T) {
+	exitCodes := []int{2, -1, 5, 0}
+	for me, expected := range map[MasterExit]int{MasterExitFirst: 2, MasterExitLast: 0, MasterExitWorst: -1} {
+		if exitCode := me.selectExitCode(exitCodes); exitCode != expected {
+			t.Errorf("%d: expected %d but got %d", me, expected, exitCode)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "syscall"
+
+// checkProcessGroups returns an error if process groups are not supported.
+func checkProcessGroups() error {
+	return nil
+}
+
+// setProcessGroup makes the process start in its own process group.
+func setProcessGroup(attr *syscall.SysProcAttr) {
+	attr.Setpgid = true
+}
+
+// signalProcessGroup sends the signal to all the processes of a process group.
+func signalProcessGroup(pgid int, sig syscall.Signal) error {
+	return syscall.Kill(-pgid, sig)
+}
+
+// processGroupAlive returns true if any process of the process group is still running;
+// its exited processes are reaped if coshell is their subreaper, e.g. when running as
+// init of a container.
+func processGroupAlive(pgid int) bool {
+	var status syscall.WaitStatus
+	for {
+		pid, err := syscall.Wait4(-pgid, &status, syscall.WNOHANG, nil)
+		if pid <= 0 || err != nil {
+			break
+		}
+	}
+	return syscall.Kill(-pgid, 0) != syscall.ESRCH
+}
@@ -0,0 +1,390 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultBlockSize is the default size of chunks when splitting input.
+const DefaultBlockSize = 1 << 20
+
+// ErrInvalidBlockSize is returned when a non-positive block size is specified.
+var ErrInvalidBlockSize = errors.New("block size must be greater than zero")
+
+// PipeConfig specifies how input is split into chunks, each to be fed to a separate command group.
+type PipeConfig struct {
+	// BlockSize is the maximum size in bytes of each chunk; a chunk is larger only
+	// when a single record does not fit in it.
+	BlockSize int64
+	// Records is the number of records in each chunk; when specified, BlockSize is ignored.
+	Records int
+	// RecordEnd is the delimiter terminating each record; defaults to newline when empty.
+	RecordEnd string
+}
+
+// DefaultPipeConfig is the default configuration for splitting input in chunks of lines.
+var DefaultPipeConfig = PipeConfig{
+	BlockSize: DefaultBlockSize,
+	RecordEnd: "\n",
+}
+
+func (pc *PipeConfig) recordEnd() []byte {
+	if pc.RecordEnd == "" {
+		return []byte{'\n'}
+	}
+	return []byte(pc.RecordEnd)
+}
+
+// readSize is the size of reads from the input of a ChunkReader.
+const readSize = 64 << 10
+
+// ChunkReader reads chunks of whole records from an input as they arrive, according to
+// a pipe configuration.
+type ChunkReader struct {
+	r      io.Reader
+	pc     PipeConfig
+	recEnd []byte
+	// buf are the bytes read but not returned yet
+	buf []byte
+	eof bool
+}
+
+// NewChunkReader returns a ChunkReader reading chunks from r.
+func NewChunkReader(r io.Reader, pc PipeConfig) (*ChunkReader, error) {
+	if pc.Records == 0 && pc.BlockSize <= 0 {
+		return nil, ErrInvalidBlockSize
+	}
+	return &ChunkReader{r: r, pc: pc, recEnd: pc.recordEnd()}, nil
+}
+
+// Next returns the next chunk, reading only as much of the input as needed to find its end;
+// io.EOF is returned once all of the input was returned.
+func (cr *ChunkReader) Next() ([]byte, error) {
+	for {
+		if end := cr.chunkEnd(); end != -1 {
+			chunk := cr.buf[:end:end]
+			cr.buf = cr.buf[end:]
+			return chunk, nil
+		}
+		if cr.eof {
+			return nil, io.EOF
+		}
+
+		if len(cr.buf) == cap(cr.buf) {
+			// returned chunks keep the previous buffer
+			buf := make([]byte, len(cr.buf), 2*len(cr.buf)+readSize)
+			copy(buf, cr.buf)
+			cr.buf = buf
+		}
+		n, err := cr.r.Read(cr.buf[len(cr.buf):cap(cr.buf)])
+		cr.buf = cr.buf[:len(cr.buf)+n]
+		if err == io.EOF {
+			cr.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// chunkEnd returns the offset right after the next chunk in the buffer, or -1 if more input
+// is needed to find it.
+func (cr *ChunkReader) chunkEnd() int {
+	data := cr.buf
+	if len(data) == 0 {
+		return -1
+	}
+	if cr.pc.Records > 0 {
+		end, ok := recordsEnd(data, cr.recEnd, cr.pc.Records)
+		if ok || cr.eof {
+			return end
+		}
+		return -1
+	}
+
+	if int64(len(data)) <= cr.pc.BlockSize {
+		if cr.eof {
+			return len(data)
+		}
+		return -1
+	}
+	if i := bytes.LastIndex(data[:cr.pc.BlockSize], cr.recEnd); i != -1 {
+		return i + len(cr.recEnd)
+	}
+	// a single record does not fit in a block
+	end, ok := recordsEnd(data, cr.recEnd, 1)
+	if ok || cr.eof {
+		return end
+	}
+	return -1
+}
+
+// ReadChunks reads all of r and splits it in chunks of whole records, according to the pipe configuration.
+func ReadChunks(r io.Reader, pc PipeConfig) ([][]byte, error) {
+	cr, err := NewChunkReader(r, pc)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+	for {
+		chunk, err := cr.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// recordsEnd returns the offset right after the n-th record of data; false is returned,
+// together with the length of data, if it contains less records.
+func recordsEnd(data, recEnd []byte, n int) (int, bool) {
+	end := 0
+	for ; n > 0; n-- {
+		i := bytes.Index(data[end:], recEnd)
+		if i == -1 {
+			return len(data), false
+		}
+		end += i + len(recEnd)
+	}
+	return end, true
+}
+
+// AddPipe adds a command group running the specified command line for each chunk; each chunk
+// is attached as standard input of its command group.
+func (cp *CommandPool) AddPipe(commandLine string, chunks [][]byte) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	env := os.Environ()
+
+	for _, chunk := range chunks {
+		err := cp.addGroup(cwd, env, bytesStdin(chunk), []string{commandLine})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pipeStream is the source of the command groups added while running with AddPipeStream.
+type pipeStream struct {
+	commandLine string
+	chunks      *ChunkReader
+	cwd         string
+	env         []string
+}
+
+// AddPipeStream adds a command group running the specified command line for each chunk read
+// from chunks, with the chunk attached as its standard input; chunks are read while command groups
+// run, each once a job slot is available for it, and reading stops once command groups are terminated.
+// Command groups are added after all the others, and DryRun does not include them.
+func (cp *CommandPool) AddPipeStream(commandLine string, chunks *ChunkReader) error {
+	if cp.stream != nil {
+		return errors.New("a pipe stream was already added")
+	}
+	// fail early for invalid command lines
+	_, err := cp.prepareCommand(commandLine)
+	if err != nil {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cp.stream = &pipeStream{
+		commandLine: commandLine,
+		chunks:      chunks,
+		cwd:         cwd,
+		env:         os.Environ(),
+	}
+	return nil
+}
+
+// feed runs a command group for each chunk of the pipe stream, taking a job slot before
+// reading each chunk; an event with index -1 is sent once reading stopped.
+func (cp *CommandPool) feed() {
+	var err error
+	for cp.sem.acquire(cp.stopping) {
+		var (
+			chunk []byte
+			cg    *CommandGroup
+		)
+		chunk, err = cp.stream.chunks.Next()
+		if err == nil {
+			cg, err = cp.addStreamGroup(chunk)
+		}
+		if err != nil || cg == nil {
+			cp.sem.release()
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		go cp.run(cg, nil, nil, true)
+	}
+	cp.notify(event{index: -1, err: err})
+}
+
+// addStreamGroup adds a command group for a chunk of the pipe stream; no command group is
+// returned once command groups are being terminated.
+func (cp *CommandPool) addStreamGroup(chunk []byte) (*CommandGroup, error) {
+	cp.groupsMu.Lock()
+	defer cp.groupsMu.Unlock()
+	select {
+	case <-cp.stopping:
+		return nil, nil
+	default:
+	}
+
+	err := cp.addGroup(cp.stream.cwd, cp.stream.env, bytesStdin(chunk), []string{cp.stream.commandLine})
+	if err != nil {
+		return nil, err
+	}
+	cg := cp.groups[len(cp.groups)-1]
+	err = cp.createCgroup(cg)
+	if err != nil {
+		// never run, thus never done
+		cp.groups = cp.groups[:len(cp.groups)-1]
+		if cp.Deinterlace {
+			cp.outputs = cp.outputs[:len(cp.outputs)-1]
+		}
+		return nil, err
+	}
+	return cg, nil
+}
+
+// Section is a byte range of a file.
+type Section struct {
+	Offset int64
+	Length int64
+}
+
+// FileSections splits the regular file at the specified path in sections of whole records,
+// according to the pipe configuration; differently from ReadChunks, each section ends at the
+// first record boundary found after BlockSize bytes, so that only the data around boundaries is read.
+func FileSections(path string, pc PipeConfig) ([]Section, error) {
+	if pc.BlockSize <= 0 {
+		return nil, ErrInvalidBlockSize
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file: %s", path)
+	}
+	size := fi.Size()
+
+	var sections []Section
+	recEnd := pc.recordEnd()
+	for start := int64(0); start < size; {
+		end := size
+		if size-start > pc.BlockSize {
+			// a delimiter ending exactly at the block boundary is also fine
+			off := start + pc.BlockSize - int64(len(recEnd))
+			if off < start {
+				off = start
+			}
+			i, err := indexAt(f, off, size, recEnd)
+			if err != nil {
+				return nil, err
+			}
+			if i != -1 {
+				end = i + int64(len(recEnd))
+			}
+		}
+		sections = append(sections, Section{start, end - start})
+		start = end
+	}
+
+	return sections, nil
+}
+
+// indexAt returns the offset of the first occurrence of sep in r between offsets off and size,
+// or -1 if there is none.
+func indexAt(r io.ReaderAt, off, size int64, sep []byte) (int64, error) {
+	buf := make([]byte, 32*1024)
+	for off < size {
+		n, err := r.ReadAt(buf, off)
+		if n == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return -1, err
+		}
+		if i := bytes.Index(buf[:n], sep); i != -1 {
+			return off + int64(i), nil
+		}
+		if n < len(sep) {
+			break
+		}
+		// keep an overlap in case the separator crosses the buffer boundary
+		off += int64(n - len(sep) + 1)
+	}
+	return -1, nil
+}
+
+// AddPipePart adds a command group running the specified command line for each section
+// of the file at the specified path; each section is attached as standard input of its command group
+// and read directly from the file.
+func (cp *CommandPool) AddPipePart(commandLine, path string, sections []Section) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	env := os.Environ()
+
+	for _, section := range sections {
+		err := cp.addGroup(cwd, env, sectionStdin(path, section), []string{commandLine})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sectionStdin returns a stdinSource reading the specified section of the file at path.
+func sectionStdin(path string, section Section) stdinSource {
+	return func() (io.Reader, io.Closer, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return io.NewSectionReader(f, section.Offset, section.Length), f, nil
+	}
+}
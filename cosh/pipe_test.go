@@ -0,0 +1,175 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadChunks(t *testing.T) {
+	const input = "alpha\nbeta\ngamma\ndelta\nepsilon"
+
+	for _, tc := range []struct {
+		pc       PipeConfig
+		expected []string
+	}{
+		{PipeConfig{BlockSize: 1024}, []string{input}},
+		{PipeConfig{BlockSize: 12}, []string{"alpha\nbeta\n", "gamma\ndelta\n", "epsilon"}},
+		{PipeConfig{BlockSize: 3}, []string{"alpha\n", "beta\n", "gamma\n", "delta\n", "epsilon"}},
+		{PipeConfig{Records: 2}, []string{"alpha\nbeta\n", "gamma\ndelta\n", "epsilon"}},
+		{PipeConfig{Records: 1, RecordEnd: "ta\n"}, []string{"alpha\nbeta\n", "gamma\ndelta\n", "epsilon"}},
+	} {
+		// chunks are the same when input arrives a byte at a time
+		for _, r := range []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input))} {
+			chunks, err := ReadChunks(r, tc.pc)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			var actual []string
+			for _, chunk := range chunks {
+				actual = append(actual, string(chunk))
+			}
+			if strings.Join(actual, "|") != strings.Join(tc.expected, "|") {
+				t.Errorf("%+v: expected %q but got %q", tc.pc, tc.expected, actual)
+			}
+		}
+	}
+}
+
+func TestPipeOutput(t *testing.T) {
+	var buf bytes.Buffer
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+
+	chunks, err := ReadChunks(strings.NewReader("1\n2\n3\n"), PipeConfig{Records: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cg := NewCommandPool(&cfg)
+	err = cg.AddPipe("cat", chunks)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cg.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cg.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Fatal("non-zero exit")
+	}
+
+	if buf.String() != "1\n2\n3\n" {
+		t.Fatalf("unexpected output: %v", buf.String())
+	}
+}
+
+func TestPipeStream(t *testing.T) {
+	var buf bytes.Buffer
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+
+	chunks, err := NewChunkReader(strings.NewReader("1\n2\n3\n"), PipeConfig{Records: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cg := NewCommandPool(&cfg)
+	err = cg.AddPipeStream("cat", chunks)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// chunks are read one at a time
+	err = cg.Start(1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cg.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Fatal("non-zero exit")
+	}
+
+	if buf.String() != "1\n2\n3\n" {
+		t.Fatalf("unexpected output: %v", buf.String())
+	}
+	if len(cg.Results()) != 3 {
+		t.Fatalf("unexpected results: %+v", cg.Results())
+	}
+	// chunks are not kept in memory once their command group completed
+	for _, g := range cg.groupList() {
+		if g.stdin != nil {
+			t.Errorf("%s still references its chunk", g.displayName())
+		}
+	}
+}
+
+func TestFileSections(t *testing.T) {
+	f, err := ioutil.TempFile("", "coshell-sections")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	const input = "alpha\nbeta\ngamma\ndelta\nepsilon"
+	_, err = f.WriteString(input)
+	f.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, tc := range []struct {
+		pc       PipeConfig
+		expected []string
+	}{
+		{PipeConfig{BlockSize: 1024}, []string{input}},
+		{PipeConfig{BlockSize: 7}, []string{"alpha\nbeta\n", "gamma\ndelta\n", "epsilon"}},
+		{PipeConfig{BlockSize: 1}, []string{"alpha\n", "beta\n", "gamma\n", "delta\n", "epsilon"}},
+		{PipeConfig{BlockSize: 4, RecordEnd: "ta\n"}, []string{"alpha\nbeta\n", "gamma\ndelta\n", "epsilon"}},
+	} {
+		sections, err := FileSections(f.Name(), tc.pc)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var actual []string
+		for _, section := range sections {
+			actual = append(actual, input[section.Offset:section.Offset+section.Length])
+		}
+		if strings.Join(actual, "|") != strings.Join(tc.expected, "|") {
+			t.Errorf("%+v: expected %q but got %q", tc.pc, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IOClass is an I/O scheduling class, as used by ionice(1).
+type IOClass int
+
+const (
+	// IONone leaves the I/O scheduling class unchanged.
+	IONone IOClass = iota
+	IORealTime
+	IOBestEffort
+	IOIdle
+)
+
+// defaultIOLevel is the level of the real-time and best-effort classes when not specified.
+const defaultIOLevel = 4
+
+// IOPriority is an I/O scheduling class with its level, from 0 (highest priority) to 7;
+// the idle class has no level.
+type IOPriority struct {
+	Class IOClass
+	Level int
+}
+
+var ioClassNames = map[string]IOClass{
+	"none":        IONone,
+	"realtime":    IORealTime,
+	"best-effort": IOBestEffort,
+	"idle":        IOIdle,
+}
+
+// ParseIOPriority parses an I/O priority in the class[:level] form, where class is
+// 'realtime', 'best-effort' or 'idle', or 1, 2 or 3 like ionice(1), and level is a number
+// from 0 to 7, 4 by default; 'none' is the IONone class, which leaves the I/O priority unchanged.
+func ParseIOPriority(s string) (IOPriority, error) {
+	name, level, hasLevel := s, "", false
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		name, level, hasLevel = s[:i], s[i+1:], true
+	}
+	class, ok := ioClassNames[strings.ToLower(name)]
+	if !ok {
+		n, err := strconv.Atoi(name)
+		if err != nil || n < int(IORealTime) || n > int(IOIdle) {
+			return IOPriority{}, fmt.Errorf("invalid I/O scheduling class %q", name)
+		}
+		class = IOClass(n)
+	}
+
+	p := IOPriority{Class: class}
+	switch {
+	case class == IOIdle || class == IONone:
+		if hasLevel {
+			return IOPriority{}, fmt.Errorf("invalid I/O priority %q: the %s class has no level", s, name)
+		}
+	case !hasLevel:
+		p.Level = defaultIOLevel
+	default:
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 {
+			return IOPriority{}, fmt.Errorf("invalid I/O priority level %q", level)
+		}
+		p.Level = n
+	}
+	return p, nil
+}
+
+// String returns the I/O priority in the form accepted by ParseIOPriority.
+func (p IOPriority) String() string {
+	for name, class := range ioClassNames {
+		if class != p.Class {
+			continue
+		}
+		if class == IOIdle || class == IONone {
+			return name
+		}
+		return fmt.Sprintf("%s:%d", name, p.Level)
+	}
+	return "none"
+}
+
+// ParseNice parses a niceness, from -20 (highest priority) to 19.
+func ParseNice(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -20 || n > 19 {
+		return 0, fmt.Errorf("invalid niceness %q", s)
+	}
+	return n, nil
+}
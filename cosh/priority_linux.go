@@ -0,0 +1,46 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "syscall"
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// setPriority sets the niceness, if not zero, and the I/O priority, if its class is not
+// IONone, of the calling thread, which are inherited by processes it starts or executes.
+func setPriority(nice int, ionice IOPriority) error {
+	if nice != 0 {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+		if err != nil {
+			return err
+		}
+	}
+	if ionice.Class != IONone {
+		prio := uintptr(ionice.Class)<<ioprioClassShift | uintptr(ionice.Level)
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, prio)
+		if errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
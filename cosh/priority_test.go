@@ -0,0 +1,98 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIOPriority(t *testing.T) {
+	for s, expected := range map[string]IOPriority{
+		"idle":          {Class: IOIdle},
+		"3":             {Class: IOIdle},
+		"best-effort":   {Class: IOBestEffort, Level: 4},
+		"best-effort:7": {Class: IOBestEffort, Level: 7},
+		"2:0":           {Class: IOBestEffort},
+		"realtime:1":    {Class: IORealTime, Level: 1},
+		"none":          {},
+	} {
+		p, err := ParseIOPriority(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if p != expected {
+			t.Errorf("%q: expected %+v but got %+v", s, expected, p)
+		}
+		if q, err := ParseIOPriority(p.String()); err != nil || q != p {
+			t.Errorf("%q: %q does not round-trip", s, p.String())
+		}
+	}
+
+	for _, s := range []string{"", "none:1", "0", "4", "idle:1", "best-effort:8", "realtime:", "2:x"} {
+		_, err := ParseIOPriority(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestParseNice(t *testing.T) {
+	for _, s := range []string{"-20", "0", "19"} {
+		_, err := ParseNice(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "-21", "20", "x"} {
+		_, err := ParseNice(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestJobPriorityOverride(t *testing.T) {
+	// zero values in a manifest override the priorities of the command pool too
+	for _, input := range []string{
+		"[inherit]\ncommand = x\n[reset]\ncommand = x\nnice = 0\nionice = none",
+		`[{"name": "inherit", "command": "x"}, {"name": "reset", "command": "x", "nice": 0, "ionice": "none"}]`,
+	} {
+		jobs, err := ReadManifest(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		cfg := DefaultCommandPoolConfig
+		cfg.Nice, cfg.IONice = 10, IOPriority{Class: IOIdle}
+		cp := NewCommandPool(&cfg)
+		err = cp.AddJobs(jobs...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if cg := cp.groups[0]; cg.nice != 10 || cg.ionice != cfg.IONice {
+			t.Errorf("%s: expected the priorities of the command pool but got %d and %v", cg.name, cg.nice, cg.ionice)
+		}
+		if cg := cp.groups[1]; cg.nice != 0 || cg.ionice != (IOPriority{}) {
+			t.Errorf("%s: expected the default priorities but got %d and %v", cg.name, cg.nice, cg.ionice)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// safeChars are the characters which never need quoting.
+const safeChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_@%+=:,./-"
+
+// ansiCQuotes are the escapes used by Join for characters in $'...' strings.
+var ansiCQuotes = map[rune]string{
+	'\a': `\a`,
+	'\b': `\b`,
+	0x1b: `\E`,
+	'\f': `\f`,
+	'\n': `\n`,
+	'\r': `\r`,
+	'\t': `\t`,
+	'\v': `\v`,
+	'\\': `\\`,
+	'\'': `\'`,
+	0x7f: `\x7f`,
+}
+
+// Quoter quotes arguments for a specific shell, joining them with spaces.
+type Quoter func(args []string) string
+
+// Quote quotes each argument with minimal quoting for POSIX sh and joins them with spaces,
+// so that the result is split back into the same arguments by Split or by any POSIX shell,
+// including bash and busybox ash. Arguments cannot contain NUL characters, as those cannot
+// be part of command line arguments.
+func Quote(args []string) string {
+	return quoteArgs(args, false)
+}
+
+// Join quotes each argument and joins them with spaces, so that the result is split back
+// into the same arguments by Split or by bash. Differently from Quote, arguments with control
+// characters, invalid UTF-8 or non-printable characters use the $'...' style of quoting.
+func Join(args []string) string {
+	return quoteArgs(args, true)
+}
+
+// QuoteForShell returns the quoter for the specified shell, either a name or a path; Join
+// is used for bash, ksh and zsh, and Quote for any other shell (e.g. sh, dash or busybox ash).
+func QuoteForShell(shell string) Quoter {
+	switch filepath.Base(shell) {
+	case "bash", "ksh", "mksh", "zsh":
+		return Join
+	}
+	return Quote
+}
+
+// reservedWords are the words which are special when used as command name.
+var reservedWords = map[string]bool{
+	"!": true, "{": true, "}": true, "case": true, "do": true, "done": true, "elif": true, "else": true,
+	"esac": true, "fi": true, "for": true, "function": true, "if": true, "in": true, "select": true,
+	"then": true, "time": true, "until": true, "while": true, "[[": true, "]]": true,
+}
+
+func quoteArgs(args []string, ansiC bool) string {
+	var buf bytes.Buffer
+	for i, arg := range args {
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		// the command name must not look like a variable assignment or a reserved word
+		if i == 0 && (reservedWords[arg] || nameLength(arg) > 0 && strings.HasPrefix(arg[nameLength(arg):], "=")) {
+			quoteSingle(&buf, arg)
+			continue
+		}
+		quoteArg(&buf, arg, ansiC)
+	}
+	return buf.String()
+}
+
+// needsQuoting returns true if the argument contains characters other than safeChars.
+func needsQuoting(arg string) bool {
+	if arg == "" {
+		return true
+	}
+	for i := 0; i < len(arg); i++ {
+		if strings.IndexByte(safeChars, arg[i]) == -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrintable returns true if the argument is valid UTF-8 with only printable characters or spaces.
+func isPrintable(arg string) bool {
+	for _, c := range arg {
+		if c == utf8.RuneError || (!unicode.IsPrint(c) && c != ' ') {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteSingle writes the argument as a single-quoted string; single quotes in the argument
+// are written by closing the quoted string, escaping the quote and reopening it.
+func quoteSingle(buf *bytes.Buffer, arg string) {
+	buf.WriteByte('\'')
+	buf.WriteString(strings.Replace(arg, "'", `'\''`, -1))
+	buf.WriteByte('\'')
+}
+
+// quoteArg writes the argument with minimal quoting; when ansiC is true, the $'...' style
+// is used for arguments which are not printable.
+func quoteArg(buf *bytes.Buffer, arg string, ansiC bool) {
+	if !needsQuoting(arg) {
+		buf.WriteString(arg)
+		return
+	}
+	if !ansiC || isPrintable(arg) {
+		if strings.Contains(arg, "'") && !strings.ContainsAny(arg, "$`\"\\!") {
+			// shorter than escaping single quotes
+			buf.WriteByte('"')
+			buf.WriteString(arg)
+			buf.WriteByte('"')
+			return
+		}
+		quoteSingle(buf, arg)
+		return
+	}
+
+	buf.WriteString("$'")
+	for i := 0; i < len(arg); {
+		c, l := utf8.DecodeRuneInString(arg[i:])
+		switch {
+		case c == utf8.RuneError && l == 1:
+			// invalid UTF-8 byte
+			fmt.Fprintf(buf, `\x%02x`, arg[i])
+		case ansiCQuotes[c] != "":
+			buf.WriteString(ansiCQuotes[c])
+		case c < ' ':
+			fmt.Fprintf(buf, `\x%02x`, c)
+		case !unicode.IsPrint(c) && c != ' ':
+			if c > 0xffff {
+				fmt.Fprintf(buf, `\U%08x`, c)
+			} else {
+				fmt.Fprintf(buf, `\u%04x`, c)
+			}
+		default:
+			buf.WriteString(arg[i : i+l])
+		}
+		i += l
+	}
+	buf.WriteByte('\'')
+}
@@ -0,0 +1,134 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestSplitANSIC(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected []string
+	}{
+		{`$'a\tb'`, []string{"a\tb"}},
+		{`x$'\n'y`, []string{"x\ny"}},
+		{`$'\x41\x4a\101\u00e8\U0001F600'`, []string{"AJAè😀"}},
+		{`$'it\'s' "$'x'"`, []string{"it's", "$'x'"}},
+		{`$'\cA\e\E\a\v\f\r\\\"\?'`, []string{"\x01\x1b\x1b\a\v\f\r\\\"?"}},
+		{`$'\q\x'`, []string{`\q\x`}},
+		{`$'a\0b' c`, []string{"a", "c"}},
+		{`$'a b' c`, []string{"a b", "c"}},
+		{`'$'a`, []string{"$a"}},
+	} {
+		actual, err := Split(tc.input)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if strings.Join(actual, "|") != strings.Join(tc.expected, "|") {
+			t.Errorf("%q: expected %q but got %q", tc.input, tc.expected, actual)
+		}
+	}
+
+	if _, err := Split(`$'abc`); err != ErrUnterminatedANSICQuote {
+		t.Errorf("expected unterminated error but got %v", err)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"echo", "a-b/c.d"}, "echo a-b/c.d"},
+		{[]string{"", "a b", "it's", "it's $HOME"}, `'' 'a b' "it's" 'it'\''s $HOME'`},
+		{[]string{"tab\there", "ünïcode"}, `$'tab\there' 'ünïcode'`},
+		{[]string{"\x1b[0m", "\xff"}, `$'\E[0m' $'\xff'`},
+	} {
+		actual := Join(tc.args)
+		if actual != tc.expected {
+			t.Errorf("%q: expected %s but got %s", tc.args, tc.expected, actual)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"echo", "a-b/c.d", "x=y"}, "echo a-b/c.d x=y"},
+		{[]string{"A=b", "c"}, "'A=b' c"},
+		{[]string{"if", "then"}, "'if' then"},
+		{[]string{"tab\there", "~", "*"}, "'tab\there' '~' '*'"},
+		{[]string{"\x1b[0m"}, "'\x1b[0m'"},
+	} {
+		actual := Quote(tc.args)
+		if actual != tc.expected {
+			t.Errorf("%q: expected %s but got %s", tc.args, tc.expected, actual)
+		}
+	}
+
+	if QuoteForShell("/bin/bash")([]string{"a\tb"}) != `$'a\tb'` {
+		t.Error("expected $'' quoting for bash")
+	}
+	if QuoteForShell("busybox")([]string{"a\tb"}) != "'a\tb'" {
+		t.Error("expected single quoting for busybox")
+	}
+}
+
+func TestQuoteSplit(t *testing.T) {
+	f := func(args []string) bool {
+		for i, arg := range args {
+			// arguments cannot contain NUL
+			args[i] = strings.Replace(arg, "\x00", "", -1)
+		}
+		split, err := Split(Quote(args))
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		return strings.Join(split, "\x00") == strings.Join(args, "\x00") && len(split) == len(args)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJoinSplit(t *testing.T) {
+	f := func(args []string) bool {
+		for i, arg := range args {
+			// arguments cannot contain NUL
+			args[i] = strings.Replace(arg, "\x00", "", -1)
+		}
+		split, err := Split(Join(args))
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		return strings.Join(split, "\x00") == strings.Join(args, "\x00") && len(split) == len(args)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadyProbe specifies how to detect that a job is ready, e.g. that a service accepts
+// connections; exactly one of its fields is set. Jobs depending on a job with a readiness
+// probe start as soon as it is ready, or once it completed successfully if that happens first.
+// Readiness probes are only specified by jobs, e.g. in manifests.
+type ReadyProbe struct {
+	// Command is a command line which succeeds once the job is ready
+	Command string
+	// TCP is a port on localhost, or a host and port, accepting connections once the job is ready
+	TCP string
+	// Unix is the path of a unix socket accepting connections once the job is ready
+	Unix string
+	// File is the path of a file which exists once the job is ready
+	File string
+	// Output is a regular expression matching a line of the output of the job once it is ready
+	Output string
+}
+
+// readyInterval is the interval between checks of readiness probes.
+const readyInterval = 100 * time.Millisecond
+
+// ParseReadyProbe parses a readiness probe, one of: cmd:COMMAND, tcp:PORT, tcp:HOST:PORT,
+// unix:PATH, file:PATH or output:REGEXP.
+func ParseReadyProbe(s string) (*ReadyProbe, error) {
+	colon := strings.IndexByte(s, ':')
+	if colon == -1 || colon == len(s)-1 {
+		return nil, fmt.Errorf("invalid readiness probe: %q", s)
+	}
+	var probe ReadyProbe
+	value := s[colon+1:]
+	switch s[:colon] {
+	case "cmd":
+		probe.Command = value
+	case "tcp":
+		probe.TCP = value
+	case "unix":
+		probe.Unix = value
+	case "file":
+		probe.File = value
+	case "output":
+		_, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		probe.Output = value
+	default:
+		return nil, fmt.Errorf("invalid readiness probe: %q", s)
+	}
+	return &probe, nil
+}
+
+// String returns the readiness probe in the format accepted by ParseReadyProbe.
+func (p ReadyProbe) String() string {
+	switch {
+	case p.Command != "":
+		return "cmd:" + p.Command
+	case p.TCP != "":
+		return "tcp:" + p.TCP
+	case p.Unix != "":
+		return "unix:" + p.Unix
+	case p.File != "":
+		return "file:" + p.File
+	}
+	return "output:" + p.Output
+}
+
+// setReadyProbe configures the readiness probe of the group; relative paths are relative
+// to the working directory of the group.
+func (cg *CommandGroup) setReadyProbe(cp *CommandPool, p ReadyProbe) error {
+	cg.readyProbe = p
+	cg.ready = make(chan struct{})
+
+	abs := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(cg.baseDir, path)
+	}
+	switch {
+	case p.Command != "":
+		// the command runs in a group of its own, so that it neither shares state with
+		// the commands of the job nor is terminated with them
+		probe, err := cp.NewCommandGroup(cg.baseDir, cg.baseEnv, ioutil.Discard, ioutil.Discard, []string{p.Command})
+		if err != nil {
+			return err
+		}
+		cg.probeGroup = probe
+		cg.probe = func() bool {
+			probe.dir, probe.env = probe.baseDir, probe.baseEnv
+			exitCode, err := probe.runCommand(probe.commands[0], nil)
+			return err == nil && exitCode == 0
+		}
+	case p.TCP != "":
+		addr := p.TCP
+		if !strings.Contains(addr, ":") {
+			addr = "localhost:" + addr
+		}
+		cg.probe = func() bool {
+			return canDial("tcp", addr)
+		}
+	case p.Unix != "":
+		path := abs(p.Unix)
+		cg.probe = func() bool {
+			return canDial("unix", path)
+		}
+	case p.File != "":
+		path := abs(p.File)
+		cg.probe = func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}
+	case p.Output != "":
+		re, err := regexp.Compile(p.Output)
+		if err != nil {
+			return err
+		}
+		m := &lineMatcher{re: re, matched: cg.setReady}
+		cg.stdout = &matchWriter{w: cg.stdout, m: m}
+		cg.stderr = &matchWriter{w: cg.stderr, m: m}
+	default:
+		return fmt.Errorf("%s: empty readiness probe", cg.displayName())
+	}
+	return nil
+}
+
+func canDial(network, addr string) bool {
+	conn, err := net.DialTimeout(network, addr, readyInterval)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// setReady marks the group as ready.
+func (cg *CommandGroup) setReady() {
+	cg.readyOnce.Do(func() {
+		close(cg.ready)
+	})
+}
+
+// isReady returns true if the group has a readiness probe which succeeded.
+func (cg *CommandGroup) isReady() bool {
+	if cg.ready == nil {
+		return false
+	}
+	select {
+	case <-cg.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkReady checks the readiness probe of the group until it succeeds or stop is closed.
+func (cg *CommandGroup) checkReady(stop <-chan struct{}) {
+	ticker := time.NewTicker(readyInterval)
+	defer ticker.Stop()
+	for {
+		if cg.probe() {
+			cg.setReady()
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// lineMatcher calls matched once for the first line matching re.
+type lineMatcher struct {
+	sync.Mutex
+	re      *regexp.Regexp
+	matched func()
+	done    bool
+}
+
+// maxMatchLine is the maximum length of lines matched by readiness probes; longer lines are truncated.
+const maxMatchLine = 64 * 1024
+
+// matchWriter writes to w while matching the lines written against m.
+type matchWriter struct {
+	w    io.Writer
+	m    *lineMatcher
+	line []byte
+}
+
+func (mw *matchWriter) Write(p []byte) (int, error) {
+	mw.m.Lock()
+	if !mw.m.done {
+		for _, c := range p {
+			if c != '\n' {
+				if len(mw.line) < maxMatchLine {
+					mw.line = append(mw.line, c)
+				}
+				continue
+			}
+			if mw.m.re.Match(mw.line) {
+				mw.m.done = true
+				mw.m.matched()
+				break
+			}
+			mw.line = mw.line[:0]
+		}
+	}
+	mw.m.Unlock()
+	return mw.w.Write(p)
+}
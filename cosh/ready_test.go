@@ -0,0 +1,150 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReadyProbe(t *testing.T) {
+	for s, expected := range map[string]ReadyProbe{
+		"cmd:pg_isready -q":   {Command: "pg_isready -q"},
+		"tcp:5432":            {TCP: "5432"},
+		"tcp:[::1]:5432":      {TCP: "[::1]:5432"},
+		"unix:/run/app.sock":  {Unix: "/run/app.sock"},
+		"file:app.pid":        {File: "app.pid"},
+		"output:^listening .": {Output: "^listening ."},
+	} {
+		probe, err := ParseReadyProbe(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if *probe != expected {
+			t.Errorf("%q: expected %+v but got %+v", s, expected, *probe)
+		}
+		if probe.String() != s {
+			t.Errorf("%q: unexpected string %q", s, probe.String())
+		}
+	}
+
+	for _, s := range []string{"", "tcp", "tcp:", "http:localhost", "output:("} {
+		_, err := ParseReadyProbe(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestReadyProbes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-ready")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// only the address is needed, the port is probed once listening again
+	addr := l.Addr().String()
+	l.Close()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+		time.Sleep(5 * time.Second)
+		l.Close()
+	}()
+
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.Masters = []string{"client"}
+
+	cp := NewCommandPool(&cfg)
+	err = cp.AddJobs(
+		JobSpec{Name: "file", Commands: []string{"sh -c 'sleep 0.2; touch file.ready; exec sleep 10'"}, Dir: dir, Ready: &ReadyProbe{File: "file.ready"}},
+		JobSpec{Name: "output", Commands: []string{"sh -c 'echo starting; sleep 0.2; echo listening on 80; exec sleep 10'"}, Ready: &ReadyProbe{Output: "^listening on"}},
+		JobSpec{Name: "cmd", Commands: []string{"sh -c 'sleep 0.2; touch cmd.ready; exec sleep 10'"}, Dir: dir, Ready: &ReadyProbe{Command: "test -e cmd.ready"}},
+		JobSpec{Name: "tcp", Commands: []string{"sleep 10"}, Ready: &ReadyProbe{TCP: addr}},
+		JobSpec{Name: "client", Commands: []string{"echo connected"}, After: []string{"file", "output", "cmd", "tcp"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 but got %d", exitCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("client did not start once services were ready")
+	}
+	output := buf.String()
+	for _, s := range []string{"connected\n", "coshell: file is ready\n", "coshell: output is ready\n", "coshell: cmd is ready\n", "coshell: tcp is ready\n"} {
+		if !strings.Contains(output, s) {
+			t.Errorf("expected %q in output %q", s, output)
+		}
+	}
+}
+
+func TestNotReady(t *testing.T) {
+	// completing successfully counts as ready
+	exitCode, output := runJobs(t,
+		JobSpec{Name: "service", Commands: []string{"true"}, Ready: &ReadyProbe{File: "/nonexistent"}},
+		JobSpec{Name: "client", Commands: []string{"echo connected"}, After: []string{"service"}},
+	)
+	if exitCode != 0 || output != "connected\n" {
+		t.Errorf("unexpected result: %d %q", exitCode, output)
+	}
+
+	exitCode, output = runJobs(t,
+		JobSpec{Name: "service", Commands: []string{"false"}, Ready: &ReadyProbe{File: "/nonexistent"}},
+		JobSpec{Name: "client", Commands: []string{"echo connected"}, After: []string{"service"}},
+	)
+	// exit codes of the service and of the client
+	if exitCode != 2 {
+		t.Errorf("expected exit code 2 but got %d", exitCode)
+	}
+	if output != "coshell: client not started because service failed before being ready\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"time"
+)
+
+// resourceInterval is the interval between checks of load and free memory while jobs are queued.
+const resourceInterval = time.Second
+
+// waitStart waits until a command group can start according to StartDelay, MaxLoad and
+// MinMemFree; false is returned if cancel is closed first. Command groups wait in turn,
+// so that StartDelay applies between any two of them.
+func (cp *CommandPool) waitStart(cancel <-chan struct{}) bool {
+	if cp.StartDelay == 0 && cp.MaxLoad == 0 && cp.MinMemFree == 0 {
+		return true
+	}
+	cp.startMu.Lock()
+	defer cp.startMu.Unlock()
+
+	for {
+		wait := time.Until(cp.lastStart.Add(cp.StartDelay))
+		if wait <= 0 {
+			ok, err := cp.resourcesAvailable()
+			if err != nil {
+				// e.g. not supported by the platform, but checked when starting
+				fmt.Fprintf(cp.Stderr, "coshell: %v\n", err)
+				ok = true
+			}
+			if ok {
+				cp.lastStart = time.Now()
+				return true
+			}
+			wait = resourceInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-cancel:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// resourcesAvailable returns true if the load average is below MaxLoad and available
+// memory is at least MinMemFree, when specified.
+func (cp *CommandPool) resourcesAvailable() (bool, error) {
+	if cp.MaxLoad != 0 {
+		load, err := loadAverage()
+		if err != nil {
+			return false, err
+		}
+		if load >= cp.MaxLoad {
+			return false, nil
+		}
+	}
+	if cp.MinMemFree != 0 {
+		free, err := memAvailable()
+		if err != nil {
+			return false, err
+		}
+		if free < cp.MinMemFree {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// loadAverage returns the load average of the last minute.
+func loadAverage() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAvg(data)
+}
+
+func parseLoadAvg(data []byte) (float64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid load average: %q", data)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// memAvailable returns the memory available for starting new processes, in bytes.
+func memAvailable() (int64, error) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	return parseMemInfo(data)
+}
+
+// parseMemInfo returns MemAvailable or, with kernels older than 3.14, an estimate
+// of it as the sum of free memory, buffers and page cache.
+func parseMemInfo(data []byte) (int64, error) {
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s value in /proc/meminfo: %q", fields[0], fields[1])
+		}
+		// values are in kB
+		values[strings.TrimSuffix(fields[0], ":")] = n * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if n, ok := values["MemAvailable"]; ok {
+		return n, nil
+	}
+	n, ok := values["MemFree"]
+	if !ok {
+		return 0, fmt.Errorf("no free memory in /proc/meminfo")
+	}
+	return n + values["Buffers"] + values["Cached"], nil
+}
@@ -0,0 +1,58 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "testing"
+
+func TestParseLoadAvg(t *testing.T) {
+	load, err := parseLoadAvg([]byte("1.52 0.98 0.61 3/1234 5678\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if load != 1.52 {
+		t.Errorf("expected 1.52 but got %v", load)
+	}
+
+	_, err = parseLoadAvg([]byte(""))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseMemInfo(t *testing.T) {
+	for data, expected := range map[string]int64{
+		"MemTotal:       16000000 kB\nMemFree:         1000000 kB\nMemAvailable:    8000000 kB\n":                              8000000 * 1024,
+		"MemTotal:       16000000 kB\nMemFree:         1000000 kB\nBuffers:          200000 kB\nCached:          3000000 kB\n": 4200000 * 1024,
+	} {
+		free, err := parseMemInfo([]byte(data))
+		if err != nil {
+			t.Errorf("%q: %v", data, err)
+			continue
+		}
+		if free != expected {
+			t.Errorf("%q: expected %d but got %d", data, expected, free)
+		}
+	}
+
+	_, err := parseMemInfo([]byte("MemTotal: 16000000 kB\n"))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
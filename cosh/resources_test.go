@@ -0,0 +1,67 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartDelay(t *testing.T) {
+	cfg := DefaultCommandPoolConfig
+	cfg.StartDelay = 100 * time.Millisecond
+
+	cp := NewCommandPool(&cfg)
+	err := cp.Add(1, "true", "true", "true", "true")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// the first job starts right away
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("jobs started within %v", elapsed)
+	}
+}
+
+func TestWaitStartCancelled(t *testing.T) {
+	cfg := DefaultCommandPoolConfig
+	cfg.StartDelay = time.Hour
+
+	cp := NewCommandPool(&cfg)
+	if !cp.waitStart(nil) {
+		t.Fatal("first start was delayed")
+	}
+	cancel := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		close(cancel)
+	})
+	if cp.waitStart(cancel) {
+		t.Fatal("start was not delayed")
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"time"
+)
+
+// RestartPolicy specifies when a command group is restarted after it exits.
+type RestartPolicy int
+
+const (
+	// RestartNever runs command groups only once.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts command groups which exit with a non-zero exit code.
+	RestartOnFailure
+	// RestartAlways restarts command groups whenever they exit.
+	RestartAlways
+)
+
+// maxRestartBackoff is the maximum delay before restarting a command group.
+const maxRestartBackoff = time.Minute
+
+// ParseRestartPolicy parses a restart policy, one of 'never', 'on-failure' or 'always'.
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	switch s {
+	case "never":
+		return RestartNever, nil
+	case "on-failure":
+		return RestartOnFailure, nil
+	case "always":
+		return RestartAlways, nil
+	}
+	return RestartNever, fmt.Errorf("invalid restart policy: %q", s)
+}
+
+// RestartConfig controls restarts of a command group; command groups are never restarted
+// once they have been terminated.
+type RestartConfig struct {
+	Policy RestartPolicy
+	// MaxRestarts, if not zero, is the maximum number of restarts within Window; once exceeded,
+	// the command group is not restarted anymore and its last exit code is used
+	MaxRestarts int
+	// Window is the period in which restarts are counted; if zero, all restarts are counted
+	Window time.Duration
+	// Backoff is the delay before restarting; it is doubled after each consecutive failure,
+	// up to one minute
+	Backoff time.Duration
+}
+
+// merge returns the configuration with the policy of override and its non-zero fields
+// replacing them.
+func (rc RestartConfig) merge(override RestartConfig) RestartConfig {
+	rc.Policy = override.Policy
+	if override.MaxRestarts != 0 {
+		rc.MaxRestarts = override.MaxRestarts
+	}
+	if override.Window != 0 {
+		rc.Window = override.Window
+	}
+	if override.Backoff != 0 {
+		rc.Backoff = override.Backoff
+	}
+	return rc
+}
+
+// restarter tracks the restarts of a command group.
+type restarter struct {
+	RestartConfig
+	// restarts are the times of the restarts within the window
+	restarts []time.Time
+	backoff  time.Duration
+}
+
+// next returns true if the command group must be restarted after exiting with the specified
+// exit code, together with the delay before restarting it; a message is returned if
+// the command group is not restarted because of too many restarts.
+func (r *restarter) next(exitCode int, now time.Time) (bool, time.Duration, string) {
+	switch r.Policy {
+	case RestartNever:
+		return false, 0, ""
+	case RestartOnFailure:
+		if exitCode == 0 {
+			return false, 0, ""
+		}
+	}
+
+	if r.Window != 0 {
+		// forget restarts which are out of the window
+		i := 0
+		for i < len(r.restarts) && now.Sub(r.restarts[i]) > r.Window {
+			i++
+		}
+		r.restarts = r.restarts[i:]
+	}
+	if r.MaxRestarts != 0 && len(r.restarts) >= r.MaxRestarts {
+		if r.Window != 0 {
+			return false, 0, fmt.Sprintf("restarted %d times within %v", len(r.restarts), r.Window)
+		}
+		return false, 0, fmt.Sprintf("restarted %d times", len(r.restarts))
+	}
+	r.restarts = append(r.restarts, now)
+
+	if exitCode == 0 {
+		r.backoff = 0
+		return true, r.Backoff, ""
+	}
+	if r.backoff == 0 {
+		r.backoff = r.Backoff
+	} else {
+		r.backoff *= 2
+		if r.backoff > maxRestartBackoff {
+			r.backoff = maxRestartBackoff
+		}
+	}
+	return true, r.backoff, ""
+}
@@ -0,0 +1,148 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestarter(t *testing.T) {
+	now := time.Now()
+	r := restarter{RestartConfig: RestartConfig{Policy: RestartOnFailure, MaxRestarts: 2, Window: time.Minute, Backoff: time.Second}}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second} {
+		restart, delay, msg := r.next(1, now)
+		if !restart || delay != expected || msg != "" {
+			t.Fatalf("restart #%d: unexpected %v, %v, %q", i, restart, delay, msg)
+		}
+	}
+	if restart, _, _ := r.next(0, now); restart {
+		t.Fatal("restarted after success with on-failure policy")
+	}
+	if restart, _, msg := r.next(1, now); restart || msg == "" {
+		t.Fatal("restarted more than the maximum number of times")
+	}
+	// restarts out of the window are forgotten
+	if restart, _, _ := r.next(1, now.Add(2*time.Minute)); !restart {
+		t.Fatal("not restarted after the window elapsed")
+	}
+
+	r = restarter{RestartConfig: RestartConfig{Policy: RestartAlways, Backoff: 40 * time.Second}}
+	for i, expected := range []time.Duration{40 * time.Second, time.Minute, 40 * time.Second} {
+		exitCode := 1
+		if i == 2 {
+			exitCode = 0
+		}
+		_, delay, _ := r.next(exitCode, now)
+		if delay != expected {
+			t.Errorf("restart #%d: expected backoff %v but got %v", i, expected, delay)
+		}
+	}
+
+	r = restarter{}
+	if restart, _, _ := r.next(1, now); restart {
+		t.Fatal("restarted with never policy")
+	}
+
+	// jobs keep the backoff of the command pool unless they specify one
+	rc := RestartConfig{Policy: RestartOnFailure, MaxRestarts: 5, Backoff: time.Second}
+	merged := rc.merge(RestartConfig{Policy: RestartAlways, Window: time.Minute})
+	if merged != (RestartConfig{Policy: RestartAlways, MaxRestarts: 5, Window: time.Minute, Backoff: time.Second}) {
+		t.Errorf("unexpected merged configuration %+v", merged)
+	}
+}
+
+func TestRestartPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-restart")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	var jobs []JobSpec
+	for name, restart := range map[string]*RestartConfig{
+		"never":      nil,
+		"on-failure": {Policy: RestartOnFailure},
+		"limited":    {Policy: RestartOnFailure, MaxRestarts: 1},
+	} {
+		// each run appends a line to the file and fails until the third run
+		path := filepath.Join(dir, name)
+		jobs = append(jobs, JobSpec{
+			Name:     name,
+			Commands: []string{fmt.Sprintf("sh -c 'echo x >> %s; test $(wc -l < %s) -ge 3'", path, path)},
+			Restart:  restart,
+		})
+	}
+
+	exitCode, _ := runJobs(t, jobs...)
+	if exitCode != 2 {
+		t.Errorf("expected exit code 2 but got %d", exitCode)
+	}
+	for name, expected := range map[string]string{"never": "x\n", "on-failure": "x\nx\nx\n", "limited": "x\nx\n"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected %d runs but got %q", name, len(expected)/2, data)
+		}
+	}
+}
+
+func TestRestartTerminated(t *testing.T) {
+	cfg := DefaultCommandPoolConfig
+	cfg.Masters = []string{"master"}
+	cfg.Restart = RestartConfig{Policy: RestartAlways, Backoff: 10 * time.Millisecond}
+	cfg.Stderr = ioutil.Discard
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(
+		JobSpec{Name: "master", Commands: []string{"sleep 0.2"}, Restart: &RestartConfig{}},
+		JobSpec{Name: "service", Commands: []string{"true"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		exitCode, err := cp.Join()
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if exitCode != 0 {
+			t.Errorf("expected exit code 0 but got %d", exitCode)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("restarted job was not terminated")
+	}
+}
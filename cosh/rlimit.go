@@ -0,0 +1,137 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RlimitResource is a resource of processes which can be limited, like with ulimit(1).
+type RlimitResource int
+
+const (
+	// RlimitAS is the maximum size of the address space, in bytes.
+	RlimitAS RlimitResource = iota
+	// RlimitNOFILE is the maximum number of open file descriptors.
+	RlimitNOFILE
+	// RlimitCPU is the maximum CPU time, in seconds.
+	RlimitCPU
+	// RlimitCORE is the maximum size of core dumps, in bytes.
+	RlimitCORE
+	// RlimitNPROC is the maximum number of processes of the user.
+	RlimitNPROC
+)
+
+// RlimInfinity is the value of limits which are not enforced.
+const RlimInfinity = ^uint64(0)
+
+var rlimitNames = map[string]RlimitResource{
+	"as":     RlimitAS,
+	"nofile": RlimitNOFILE,
+	"cpu":    RlimitCPU,
+	"core":   RlimitCORE,
+	"nproc":  RlimitNPROC,
+}
+
+// Rlimit is a soft and hard limit of a resource of processes.
+type Rlimit struct {
+	Resource   RlimitResource
+	Soft, Hard uint64
+}
+
+// ParseRlimits parses a list of limits separated by commas or spaces, in the name=soft[:hard]
+// form, where name is as, nofile, cpu, core or nproc, and hard is the same as soft if not specified;
+// sizes of as and core can have a binary suffix, e.g. "as=4G", and limits can be 'unlimited'.
+func ParseRlimits(s string) ([]Rlimit, error) {
+	var limits []Rlimit
+	for _, item := range splitList(s) {
+		eq := strings.IndexByte(item, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("invalid resource limit %q", item)
+		}
+		limit, err := parseRlimit(item[:eq], item[eq+1:])
+		if err != nil {
+			return nil, err
+		}
+		limits = mergeRlimits(limits, []Rlimit{limit})
+	}
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("invalid resource limits %q", s)
+	}
+	return limits, nil
+}
+
+// parseRlimit parses the soft[:hard] limit of the named resource.
+func parseRlimit(name, value string) (Rlimit, error) {
+	resource, ok := rlimitNames[strings.ToLower(name)]
+	if !ok {
+		return Rlimit{}, fmt.Errorf("unknown resource %q", name)
+	}
+	limit := Rlimit{Resource: resource}
+	soft, hard := value, value
+	if i := strings.IndexByte(value, ':'); i != -1 {
+		soft, hard = value[:i], value[i+1:]
+	}
+	var err error
+	limit.Soft, err = parseRlimitValue(resource, soft)
+	if err == nil {
+		limit.Hard, err = parseRlimitValue(resource, hard)
+	}
+	if err != nil {
+		return Rlimit{}, fmt.Errorf("invalid %s limit %q", name, value)
+	}
+	if limit.Soft > limit.Hard {
+		return Rlimit{}, fmt.Errorf("invalid %s limit %q: soft limit is greater than hard limit", name, value)
+	}
+	return limit, nil
+}
+
+func parseRlimitValue(resource RlimitResource, s string) (uint64, error) {
+	if s == "unlimited" {
+		return RlimInfinity, nil
+	}
+	if resource == RlimitAS || resource == RlimitCORE {
+		n, err := ParseSize(s)
+		return uint64(n), err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// mergeRlimits returns the limits with those of override replacing the ones of the same resource.
+func mergeRlimits(limits, override []Rlimit) []Rlimit {
+	byResource := map[RlimitResource]Rlimit{}
+	for _, l := range limits {
+		byResource[l.Resource] = l
+	}
+	for _, l := range override {
+		byResource[l.Resource] = l
+	}
+	r := make([]Rlimit, 0, len(byResource))
+	for _, l := range byResource {
+		r = append(r, l)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Resource < r[j].Resource
+	})
+	return r
+}
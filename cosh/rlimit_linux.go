@@ -0,0 +1,42 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "syscall"
+
+// rlimitResources are the Linux numbers of resources.
+var rlimitResources = map[RlimitResource]int{
+	RlimitAS:     syscall.RLIMIT_AS,
+	RlimitNOFILE: syscall.RLIMIT_NOFILE,
+	RlimitCPU:    syscall.RLIMIT_CPU,
+	RlimitCORE:   syscall.RLIMIT_CORE,
+	RlimitNPROC:  6,
+}
+
+// setRlimits sets the resource limits of the process, which are kept by commands it executes.
+func setRlimits(limits []Rlimit) error {
+	for _, l := range limits {
+		err := syscall.Setrlimit(rlimitResources[l.Resource], &syscall.Rlimit{Cur: l.Soft, Max: l.Hard})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
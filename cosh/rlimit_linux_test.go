@@ -0,0 +1,36 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "testing"
+
+func TestRlimits(t *testing.T) {
+	exitCode, output := runJobs(t,
+		JobSpec{Commands: []string{"sh -c 'ulimit -n; ulimit -Hn; ulimit -c'"}, Rlimits: []Rlimit{{RlimitNOFILE, 64, 128}, {RlimitCORE, 0, 0}}},
+		JobSpec{Commands: []string{"sh -c 'ulimit -t'"}, Rlimits: []Rlimit{{RlimitCPU, 30, RlimInfinity}}},
+	)
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 but got %d", exitCode)
+	}
+	expected := "64\n128\n0\n30\n"
+	if output != expected {
+		t.Errorf("expected %q but got %q", expected, output)
+	}
+}
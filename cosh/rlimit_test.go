@@ -0,0 +1,62 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRlimits(t *testing.T) {
+	for s, expected := range map[string][]Rlimit{
+		"nofile=1024":                 {{RlimitNOFILE, 1024, 1024}},
+		"NOFILE=1024:4096, core=0":    {{RlimitNOFILE, 1024, 4096}, {RlimitCORE, 0, 0}},
+		"as=1G cpu=60:unlimited":      {{RlimitAS, 1 << 30, 1 << 30}, {RlimitCPU, 60, RlimInfinity}},
+		"nproc=10,nproc=20":           {{RlimitNPROC, 20, 20}},
+		"core=unlimited,as=unlimited": {{RlimitAS, RlimInfinity, RlimInfinity}, {RlimitCORE, RlimInfinity, RlimInfinity}},
+	} {
+		limits, err := ParseRlimits(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(limits, expected) {
+			t.Errorf("%q: expected %v but got %v", s, expected, limits)
+		}
+	}
+
+	for _, s := range []string{"", "nofile", "files=1", "nofile=x", "nofile=-1", "nofile=2:1", "cpu=1m", "as=1X"} {
+		_, err := ParseRlimits(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestMergeRlimits(t *testing.T) {
+	limits := mergeRlimits(
+		[]Rlimit{{RlimitNOFILE, 1024, 1024}, {RlimitCORE, 0, 0}},
+		[]Rlimit{{RlimitAS, 1 << 30, 1 << 30}, {RlimitCORE, RlimInfinity, RlimInfinity}},
+	)
+	expected := []Rlimit{{RlimitAS, 1 << 30, 1 << 30}, {RlimitNOFILE, 1024, 1024}, {RlimitCORE, RlimInfinity, RlimInfinity}}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Errorf("expected %v but got %v", expected, limits)
+	}
+}
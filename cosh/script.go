@@ -0,0 +1,364 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned when a command line cannot be parsed in shell-less mode.
+type SyntaxError struct {
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return "syntax error: " + e.Msg
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenOperator
+	// tokenIONumber is a file descriptor number preceding a redirection operator, e.g. the 2 in 2>&1
+	tokenIONumber
+)
+
+type token struct {
+	kind tokenKind
+	// text is the raw source of words, including quotes and escapes, or the operator itself
+	text string
+}
+
+// operators are sorted so that the longest match comes first.
+var operators = []string{"&&", "||", ">>", ">&", "<&", "<<", "|", "&", ";", ">", "<", "\n"}
+
+// lex splits input into words and operators; words are validated but kept in their
+// raw form so that they can be unquoted and expanded only when executed.
+func lex(input string, parameters bool) ([]token, error) {
+	var (
+		tokens []token
+		buf    bytes.Buffer
+		s      = splitter{operators: true, parameters: parameters}
+	)
+
+	for len(input) > 0 {
+		c, l := utf8.DecodeRuneInString(input)
+		if c != '\n' && strings.ContainsRune(splitChars, c) {
+			input = input[l:]
+			continue
+		}
+		if c == escapeChar && strings.HasPrefix(input[l:], "\n") {
+			// line continuation
+			input = input[l+1:]
+			continue
+		}
+		if c == '\n' || strings.ContainsRune(operatorChars, c) {
+			for _, op := range operators {
+				if strings.HasPrefix(input, op) {
+					tokens = append(tokens, token{tokenOperator, op})
+					input = input[len(op):]
+					break
+				}
+			}
+			continue
+		}
+
+		if c == '#' {
+			// comment until the end of line
+			if end := strings.IndexByte(input, '\n'); end != -1 {
+				input = input[end:]
+			} else {
+				input = ""
+			}
+			continue
+		}
+
+		_, remainder, err := s.splitWord(input, &buf)
+		if err != nil {
+			return nil, err
+		}
+		raw := input[:len(input)-len(remainder)]
+		input = remainder
+
+		kind := tokenWord
+		if isDigits(raw) && (strings.HasPrefix(input, ">") || strings.HasPrefix(input, "<")) {
+			kind = tokenIONumber
+		}
+		tokens = append(tokens, token{kind, raw})
+	}
+
+	return tokens, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// script is a command line parsed in shell-less mode; it is a list of and-or lists
+// separated by ';' or newlines.
+type script struct {
+	lists []*andOrList
+}
+
+// andOrList is a list of pipelines separated by '&&' or '||' operators.
+type andOrList struct {
+	pipelines []*pipeline
+	// operators[i] is the operator between pipelines i and i+1
+	operators []string
+}
+
+// pipeline is a list of simple commands with the standard output of each connected
+// to the standard input of the next one.
+type pipeline struct {
+	commands []*simpleCommand
+}
+
+// simpleCommand is a command with its arguments and redirections; words are in raw form.
+type simpleCommand struct {
+	words     []string
+	redirects []*redirect
+}
+
+// redirect is a redirection of a file descriptor, applied in order of appearance.
+type redirect struct {
+	fd int
+	// op is one of '<', '>', '>>', '>&' or '<&'
+	op string
+	// target is a raw word for files, or the file descriptor number for duplication operators
+	target string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseScript parses the specified command line into a script; when parameters is true,
+// words can contain parameter expansions.
+func parseScript(input string, parameters bool) (*script, error) {
+	tokens, err := lex(input, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	var s script
+	for {
+		// skip empty commands
+		for p.accept(";") || p.accept("\n") {
+		}
+		if p.done() {
+			break
+		}
+
+		list, err := p.andOrList()
+		if err != nil {
+			return nil, err
+		}
+		s.lists = append(s.lists, list)
+
+		if p.done() {
+			break
+		}
+		if !p.accept(";") && !p.accept("\n") {
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(s.lists) == 0 {
+		return nil, ErrEmptyCommandLine
+	}
+
+	return &s, nil
+}
+
+func (p *parser) done() bool {
+	return p.pos == len(p.tokens)
+}
+
+func (p *parser) peek() *token {
+	if p.done() {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+// accept consumes the next token if it is the specified operator.
+func (p *parser) accept(op string) bool {
+	t := p.peek()
+	if t == nil || t.kind != tokenOperator || t.text != op {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t == nil {
+		return &SyntaxError{"unexpected end of command line"}
+	}
+	if t.text == "\n" {
+		return &SyntaxError{"unexpected newline"}
+	}
+	return &SyntaxError{fmt.Sprintf("unexpected %q", t.text)}
+}
+
+func (p *parser) andOrList() (*andOrList, error) {
+	var list andOrList
+	for {
+		pl, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		list.pipelines = append(list.pipelines, pl)
+
+		var op string
+		if p.accept("&&") {
+			op = "&&"
+		} else if p.accept("||") {
+			op = "||"
+		} else {
+			return &list, nil
+		}
+		list.operators = append(list.operators, op)
+
+		// a newline is allowed after the operator
+		for p.accept("\n") {
+		}
+	}
+}
+
+func (p *parser) pipeline() (*pipeline, error) {
+	var pl pipeline
+	for {
+		cmd, err := p.simpleCommand()
+		if err != nil {
+			return nil, err
+		}
+		pl.commands = append(pl.commands, cmd)
+
+		if !p.accept("|") {
+			return &pl, nil
+		}
+		for p.accept("\n") {
+		}
+	}
+}
+
+func (p *parser) simpleCommand() (*simpleCommand, error) {
+	var cmd simpleCommand
+	for {
+		t := p.peek()
+		if t == nil {
+			break
+		}
+		if t.kind == tokenWord {
+			cmd.words = append(cmd.words, t.text)
+			p.pos++
+			continue
+		}
+
+		fd := -1
+		if t.kind == tokenIONumber {
+			_, err := fmt.Sscan(t.text, &fd)
+			if err != nil {
+				return nil, &SyntaxError{fmt.Sprintf("invalid file descriptor %q", t.text)}
+			}
+			p.pos++
+		}
+
+		r, err := p.redirect(fd)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			// not a redirection operator
+			break
+		}
+		cmd.redirects = append(cmd.redirects, r)
+	}
+
+	if len(cmd.words) == 0 {
+		if len(cmd.redirects) != 0 {
+			return nil, &SyntaxError{"redirection without a command"}
+		}
+		return nil, p.unexpected()
+	}
+
+	return &cmd, nil
+}
+
+// redirect parses a redirection operator and its target, if any.
+func (p *parser) redirect(fd int) (*redirect, error) {
+	t := p.peek()
+	if t == nil || t.kind != tokenOperator {
+		return nil, nil
+	}
+
+	r := redirect{fd: fd, op: t.text}
+	switch t.text {
+	case "<", "<&":
+		if r.fd == -1 {
+			r.fd = 0
+		}
+	case ">", ">>", ">&":
+		if r.fd == -1 {
+			r.fd = 1
+		}
+	case "<<":
+		return nil, &SyntaxError{"here-documents are not supported"}
+	case "&":
+		return nil, &SyntaxError{"background commands are not supported"}
+	default:
+		if fd != -1 {
+			return nil, p.unexpected()
+		}
+		return nil, nil
+	}
+	p.pos++
+
+	target := p.peek()
+	if target == nil || target.kind == tokenOperator {
+		return nil, p.unexpected()
+	}
+	p.pos++
+	r.target = target.text
+
+	if r.op == ">&" || r.op == "<&" {
+		if !isDigits(r.target) {
+			return nil, &SyntaxError{fmt.Sprintf("invalid file descriptor %q", r.target)}
+		}
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,137 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	for _, tc := range []struct {
+		input                  string
+		lists, pipes, commands int
+	}{
+		{"echo a", 1, 1, 1},
+		{"echo a; echo b", 2, 2, 2},
+		{"echo a && echo b || echo c", 1, 3, 3},
+		{"echo a | tr a b | cat > out", 1, 1, 3},
+		{"cmd 2>&1 >>log </dev/null", 1, 1, 1},
+		{"echo 'a | b' \"&&\" c\\;d", 1, 1, 1},
+		{"echo a &&\necho b\necho c;", 2, 3, 3},
+		{"echo a # b; echo c\necho d", 2, 2, 2},
+	} {
+		s, err := parseScript(tc.input, false)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		var pipes, commands int
+		for _, list := range s.lists {
+			pipes += len(list.pipelines)
+			for _, pl := range list.pipelines {
+				commands += len(pl.commands)
+			}
+		}
+		if len(s.lists) != tc.lists || pipes != tc.pipes || commands != tc.commands {
+			t.Errorf("%q: expected %d/%d/%d lists/pipelines/commands but got %d/%d/%d", tc.input,
+				tc.lists, tc.pipes, tc.commands, len(s.lists), pipes, commands)
+		}
+	}
+
+	for _, input := range []string{"", ";", "echo a |", "| cat", "echo a &", "cat <", "echo a && && b", "> out", "cat << EOF", "echo 1>&x"} {
+		_, err := parseScript(input, false)
+		if err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	s, err := parseScript("cmd a 2>&1 >>log <in 3> x", false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sc := s.lists[0].pipelines[0].commands[0]
+	if len(sc.words) != 2 {
+		t.Fatalf("unexpected words: %q", sc.words)
+	}
+	expected := []redirect{{2, ">&", "1"}, {1, ">>", "log"}, {0, "<", "in"}, {3, ">", "x"}}
+	if len(sc.redirects) != len(expected) {
+		t.Fatalf("expected %d redirections but got %d", len(expected), len(sc.redirects))
+	}
+	for i, r := range sc.redirects {
+		if *r != expected[i] {
+			t.Errorf("expected %+v but got %+v", expected[i], *r)
+		}
+	}
+}
+
+func TestScriptOutput(t *testing.T) {
+	var exitCode int
+	var buf bytes.Buffer
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+
+	cg := NewCommandPool(&cfg)
+	err := cg.Add(1, []string{
+		"echo alpha | tr a-z A-Z",
+		"false && echo no || echo beta",
+		"sh -c 'echo gamma >&2' 2>&1 | cat",
+		"false; true && echo delta",
+	}...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cg.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err = cg.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Fatal("non-zero exit")
+	}
+
+	if buf.String() != "ALPHA\nbeta\ngamma\ndelta\n" {
+		t.Fatalf("unexpected output: %v", buf.String())
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	// like a shell, commands which cannot be started only fail
+	exitCode, output := runJobs(t,
+		JobSpec{Commands: []string{"coshell-missing-command || echo ok"}},
+		JobSpec{Commands: []string{"coshell-missing-command"}},
+		JobSpec{Commands: []string{"/"}},
+	)
+	if exitCode != 127+126 {
+		t.Errorf("expected exit code %d but got %d", 127+126, exitCode)
+	}
+	notFound := `coshell: exec: "coshell-missing-command": executable file not found in $PATH` + "\n"
+	expected := notFound + "ok\n" + notFound + `coshell: exec: "/": is a directory` + "\n"
+	if output != expected {
+		t.Errorf("expected output %q but got %q", expected, output)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "sync"
+
+// semaphore limits the number of command groups running concurrently; unlike a buffered
+// channel, its limit can be changed while command groups run.
+type semaphore struct {
+	sync.Mutex
+	limit, used int
+	// changed is closed, and replaced, whenever a slot is released or the limit changes
+	changed chan struct{}
+}
+
+func newSemaphore(limit int) *semaphore {
+	return &semaphore{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire waits for a free slot and takes it; false is returned, without taking a slot,
+// if cancel is closed first.
+func (s *semaphore) acquire(cancel <-chan struct{}) bool {
+	for {
+		s.Lock()
+		if s.used < s.limit {
+			s.used++
+			s.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.Unlock()
+
+		select {
+		case <-changed:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// release frees a slot taken with acquire.
+func (s *semaphore) release() {
+	s.Lock()
+	s.used--
+	s.notify()
+	s.Unlock()
+}
+
+// setLimit changes the number of slots; when it is lowered, command groups already running
+// are not affected, but no other one starts until enough slots are released.
+func (s *semaphore) setLimit(limit int) {
+	s.Lock()
+	s.limit = limit
+	s.notify()
+	s.Unlock()
+}
+
+func (s *semaphore) getLimit() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.limit
+}
+
+// notify wakes up all waiters; it must be called with the lock held.
+func (s *semaphore) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
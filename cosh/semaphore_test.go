@@ -0,0 +1,98 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := newSemaphore(1)
+	if !s.acquire(nil) {
+		t.Fatal("could not acquire free slot")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- s.acquire(nil)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired slot beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// raising the limit frees a slot
+	s.setLimit(2)
+	if !<-acquired {
+		t.Fatal("could not acquire slot after raising the limit")
+	}
+
+	// lowering the limit does not affect slots already taken
+	s.setLimit(1)
+	s.release()
+	cancel := make(chan struct{})
+	go func() {
+		acquired <- s.acquire(cancel)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired slot beyond the lowered limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(cancel)
+	if <-acquired {
+		t.Fatal("acquired slot after cancellation")
+	}
+
+	s.release()
+	if !s.acquire(nil) {
+		t.Fatal("could not acquire released slot")
+	}
+}
+
+func TestSetJobs(t *testing.T) {
+	cp := NewCommandPool(nil)
+	err := cp.Add(1, "sleep 0.2", "sleep 0.2", "sleep 0.2", "sleep 0.2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cp.Jobs() != 1 {
+		t.Errorf("expected 1 job but got %d", cp.Jobs())
+	}
+	cp.SetJobs(0)
+	if cp.Jobs() != 4 {
+		t.Errorf("expected 4 jobs but got %d", cp.Jobs())
+	}
+	_, err = cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if time.Since(start) > 600*time.Millisecond {
+		t.Error("number of jobs was not raised while running")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// shutdownInterval is the interval between checks of processes left after termination.
+const shutdownInterval = 50 * time.Millisecond
+
+// startShutdown starts the shutdown deadline, once; processes still running when it passes
+// are killed and reported.
+func (cp *CommandPool) startShutdown() {
+	cp.shutdownOnce.Do(func() {
+		// command groups added afterwards would not be stopped
+		cp.groupsMu.Lock()
+		close(cp.stopping)
+		cp.groupsMu.Unlock()
+
+		if cp.ShutdownTimeout > 0 {
+			time.AfterFunc(cp.ShutdownTimeout, func() {
+				close(cp.deadline)
+			})
+		}
+	})
+}
+
+// shutdown starts the shutdown deadline and stops all command groups except the specified
+// one, without waiting for them.
+func (cp *CommandPool) shutdown(exceptIndex int) {
+	cp.startShutdown()
+	go cp.terminateAll(exceptIndex)
+}
+
+// survivors returns the processes started by the command groups which did not exit yet
+// and, with process groups, the process groups with any process left.
+func (cp *CommandPool) survivors() []string {
+	var r []string
+	for _, cg := range cp.groupList() {
+		r = append(r, cg.survivors()...)
+		if cg.probeGroup != nil {
+			r = append(r, cg.probeGroup.survivors()...)
+		}
+	}
+	return r
+}
+
+func (cg *CommandGroup) survivors() []string {
+	cg.Lock()
+	defer cg.Unlock()
+
+	var r []string
+	for cmd := range cg.running {
+		r = append(r, fmt.Sprintf("process %d (%s)", cmd.Process.Pid, strings.Join(cmd.Args, " ")))
+	}
+	for pgid, cmd := range cg.pgroups {
+		if _, ok := cg.running[cmd]; ok {
+			continue
+		}
+		if !processGroupAlive(pgid) {
+			delete(cg.pgroups, pgid)
+			continue
+		}
+		r = append(r, fmt.Sprintf("process group %d (%s)", pgid, strings.Join(cmd.Args, " ")))
+	}
+	if len(r) == 0 && cg.cgroup != nil && cg.cgroup.populated() {
+		r = append(r, fmt.Sprintf("processes of cgroup %s", cg.cgroup.dir))
+	}
+	return r
+}
+
+// waitProcesses waits for all processes started by the command groups to exit; processes still
+// running when the shutdown deadline passes are killed and reported.
+func (cp *CommandPool) waitProcesses() {
+	ticker := time.NewTicker(shutdownInterval)
+	defer ticker.Stop()
+	for {
+		if len(cp.survivors()) == 0 {
+			return
+		}
+		select {
+		case <-ticker.C:
+			continue
+		case <-cp.deadline:
+		}
+
+		for _, s := range cp.survivors() {
+			fmt.Fprintf(cp.Stderr, "coshell: %s did not exit within %v, killing it\n", s, cp.ShutdownTimeout)
+		}
+		cp.Kill()
+		return
+	}
+}
@@ -0,0 +1,129 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownBarrier(t *testing.T) {
+	// output is written to a file rather than to a pipe, which would be waited for until closed
+	// by all processes
+	f, err := ioutil.TempFile("", "coshell-shutdown")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cfg := DefaultCommandPoolConfig
+	cfg.Stdout = f
+	cfg.ProcessGroups = true
+	cfg.ShutdownTimeout = 5 * time.Second
+
+	cp := NewCommandPool(&cfg)
+	// the background process is left behind by the shell
+	err = cp.Add(1, "sh -c 'sleep 10 & echo $!'")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// otherwise the background process is reparented to init, which might not reap it
+	err = SetSubreaper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 but got %d", exitCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("process left behind was not terminated")
+	}
+
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if syscall.Kill(pid, 0) != syscall.ESRCH {
+		t.Errorf("process %d left behind is still running", pid)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.Masters = []string{"0"}
+	cfg.TermSignal = syscall.SIGTERM
+	cfg.KillAfter = time.Minute
+	cfg.ShutdownTimeout = 300 * time.Millisecond
+
+	cp := NewCommandPool(&cfg)
+	err := cp.Add(1, "sleep 0.1", "sh -c 'trap \"\" TERM; while true; do sleep 0.05; done'")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("shutdown deadline was not enforced")
+	}
+
+	output := buf.String()
+	for _, s := range []string{"coshell: group #1 did not complete within 300ms\n", "did not exit within 300ms, killing it\n"} {
+		if !strings.Contains(output, s) {
+			t.Errorf("expected %q in output %q", s, output)
+		}
+	}
+}
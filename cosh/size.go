@@ -0,0 +1,70 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeSuffixes = map[byte]int64{
+	'k': 1 << 10,
+	'K': 1 << 10,
+	'm': 1 << 20,
+	'M': 1 << 20,
+	'g': 1 << 30,
+	'G': 1 << 30,
+	't': 1 << 40,
+	'T': 1 << 40,
+}
+
+// ParseSize parses a size in bytes with an optional binary suffix (k, M, G or T), e.g. "1M" for 1048576 bytes.
+func ParseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	multiplier := int64(1)
+	if m, ok := sizeSuffixes[s[len(s)-1]]; ok {
+		multiplier = m
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	if n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("size too large: %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// FormatSize formats a size in bytes with a binary suffix, e.g. "1.5M" for 1572864 bytes.
+func FormatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"k", 1 << 10}} {
+		if n >= unit.size {
+			return strconv.FormatFloat(float64(n)/float64(unit.size), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,38 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "testing"
+
+func TestFormatSize(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:             "0",
+		1000:          "1000",
+		1536:          "1.5k",
+		64 << 20:      "64.0M",
+		3 << 30:       "3.0G",
+		(1 << 40) + 1: "1.0T",
+	} {
+		s := FormatSize(n)
+		if s != expected {
+			t.Errorf("%d: expected %q but got %q", n, expected, s)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// StdinMode specifies which standard input is attached to commands.
+type StdinMode int
+
+const (
+	// StdinNone attaches no standard input; os/exec connects commands to the null device.
+	StdinNone StdinMode = iota
+	// StdinNull attaches the null device, opened once for each command group.
+	StdinNull
+	// StdinTTY attaches the controlling terminal, opened once for each command group.
+	StdinTTY
+	// StdinFile attaches the file at StdinPath, opened once for each command group.
+	StdinFile
+	// StdinInheritFirst attaches coshell's own standard input to the first command group;
+	// all other command groups have no standard input attached.
+	StdinInheritFirst
+)
+
+// ErrEmptyStdinPath is returned when the file stdin mode is used without a path.
+var ErrEmptyStdinPath = errors.New("empty path for stdin file")
+
+// stdinSource opens the standard input of a command group each time its commands run,
+// including retries, restarts and finally commands; the returned closer, if not nil,
+// is called once they have finished.
+type stdinSource func() (io.Reader, io.Closer, error)
+
+// ParseStdinMode parses a stdin mode specification as accepted by the --stdin option,
+// one of: none, null, tty, file:PATH or inherit-first.
+// The path is returned only for the file mode.
+func ParseStdinMode(s string) (StdinMode, string, error) {
+	switch s {
+	case "none":
+		return StdinNone, "", nil
+	case "null":
+		return StdinNull, "", nil
+	case "tty":
+		return StdinTTY, "", nil
+	case "inherit-first":
+		return StdinInheritFirst, "", nil
+	}
+	if strings.HasPrefix(s, "file:") {
+		path := strings.TrimPrefix(s, "file:")
+		if path == "" {
+			return StdinNone, "", ErrEmptyStdinPath
+		}
+		return StdinFile, path, nil
+	}
+	return StdinNone, "", fmt.Errorf("invalid stdin mode: %q", s)
+}
+
+// openFileStdin returns a stdinSource opening the specified file for reading.
+func openFileStdin(path string) stdinSource {
+	return func() (io.Reader, io.Closer, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, f, nil
+	}
+}
+
+// readerStdin returns a stdinSource always providing r, which will not be closed; what was
+// read by previous runs is not read again.
+func readerStdin(r io.Reader) stdinSource {
+	return func() (io.Reader, io.Closer, error) {
+		return r, nil, nil
+	}
+}
+
+// bytesStdin returns a stdinSource providing data from the beginning on each run.
+func bytesStdin(data []byte) stdinSource {
+	return func() (io.Reader, io.Closer, error) {
+		return bytes.NewReader(data), nil, nil
+	}
+}
+
+// stdinFor returns the stdin source for the command group at the specified index,
+// according to the configured stdin mode.
+func (cp *CommandPool) stdinFor(index int) (stdinSource, error) {
+	switch cp.Stdin {
+	case StdinNone:
+		return nil, nil
+	case StdinNull:
+		return openFileStdin(os.DevNull), nil
+	case StdinTTY:
+		return openFileStdin("/dev/tty"), nil
+	case StdinFile:
+		if cp.StdinPath == "" {
+			return nil, ErrEmptyStdinPath
+		}
+		return openFileStdin(cp.StdinPath), nil
+	case StdinInheritFirst:
+		if index == 0 {
+			return readerStdin(os.Stdin), nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid stdin mode: %d", cp.Stdin)
+}
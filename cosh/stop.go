@@ -0,0 +1,139 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultStopGrace is the time processes have to exit after the stop signal, unless specified.
+const defaultStopGrace = 10 * time.Second
+
+// signals are the signals accepted by ParseSignal; more are added on Unix.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal name, with or without the SIG prefix, or number.
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n >= 65 {
+			return 0, fmt.Errorf("invalid signal number: %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("invalid signal: %q", s)
+	}
+	return sig, nil
+}
+
+// stop terminates the command group sending its stop signal to the running processes, which
+// are killed if they did not exit within the grace period; finally commands are spared by the
+// stop signal, but not by killing. It returns once the group completed.
+func (cg *CommandGroup) stop() {
+	if cg.stopSignal == 0 || cg.stopSignal == syscall.SIGKILL {
+		cg.terminate()
+	} else {
+		cg.signal(cg.stopSignal, false)
+	}
+	grace := cg.stopGrace
+	if grace == 0 {
+		grace = defaultStopGrace
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-cg.done:
+		return
+	case <-timer.C:
+	}
+
+	fmt.Fprintf(cg.stderr, "coshell: %s did not stop within %v, killing it\n", cg.displayName(), grace)
+	cg.kill()
+	<-cg.done
+}
+
+// shutdownStages returns the command groups to stop, except the specified one, in stages:
+// groups are stopped only after all the groups depending on them, and by increasing stop
+// priority.
+func (cp *CommandPool) shutdownStages(exceptIndex int) [][]*CommandGroup {
+	all := cp.groupList()
+	// dependents counts the groups depending on each group which are not stopped yet
+	dependents := make([]int, len(all))
+	for i, deps := range cp.deps {
+		if i == exceptIndex {
+			continue
+		}
+		for _, d := range deps {
+			dependents[d]++
+		}
+	}
+	stopped := make([]bool, len(all))
+	if exceptIndex >= 0 && exceptIndex < len(all) {
+		stopped[exceptIndex] = true
+	}
+
+	var stages [][]*CommandGroup
+	for {
+		var stage []int
+		for i, cg := range all {
+			if stopped[i] || dependents[i] != 0 {
+				continue
+			}
+			if len(stage) != 0 {
+				priority := all[stage[0]].stopPriority
+				if cg.stopPriority > priority {
+					continue
+				}
+				if cg.stopPriority < priority {
+					stage = stage[:0]
+				}
+			}
+			stage = append(stage, i)
+		}
+		if len(stage) == 0 {
+			// dependency cycles are rejected when starting
+			return stages
+		}
+
+		groups := make([]*CommandGroup, len(stage))
+		for j, i := range stage {
+			groups[j] = all[i]
+			stopped[i] = true
+			if i < len(cp.deps) {
+				for _, d := range cp.deps[i] {
+					dependents[d]--
+				}
+			}
+		}
+		stages = append(stages, groups)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"SIGTERM": syscall.SIGTERM,
+		"int":     syscall.SIGINT,
+		"9":       syscall.SIGKILL,
+	} {
+		sig, err := ParseSignal(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if sig != expected {
+			t.Errorf("%q: expected %v but got %v", s, expected, sig)
+		}
+	}
+
+	for _, s := range []string{"", "SIG", "TERMINATE", "0", "-1", "100"} {
+		_, err := ParseSignal(s)
+		if err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestShutdownStages(t *testing.T) {
+	cp := NewCommandPool(nil)
+	err := cp.AddJobs(
+		JobSpec{Name: "master", Commands: []string{"true"}},
+		JobSpec{Name: "db", Commands: []string{"true"}, StopPriority: 1},
+		JobSpec{Name: "app", Commands: []string{"true"}, After: []string{"db", "cache"}},
+		JobSpec{Name: "worker", Commands: []string{"true"}, After: []string{"app"}, StopPriority: 1},
+		JobSpec{Name: "cache", Commands: []string{"true"}, StopPriority: 2},
+		JobSpec{Name: "client", Commands: []string{"true"}, After: []string{"master"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cp.deps, err = cp.dependencies()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var stages [][]string
+	for _, stage := range cp.shutdownStages(0) {
+		var names []string
+		for _, cg := range stage {
+			names = append(names, cg.name)
+		}
+		stages = append(stages, names)
+	}
+	expected := [][]string{{"client"}, {"worker"}, {"app"}, {"db"}, {"cache"}}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Errorf("expected stages %v but got %v", expected, stages)
+	}
+}
+
+func TestStopSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coshell-stop")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stopped")
+
+	// services record when they stop, except the stubborn one, which must be killed
+	service := func(name string) string {
+		return "sh -c 'trap \"echo " + name + " >> " + path + "; exit 0\" TERM; while true; do sleep 0.05; done'"
+	}
+	cfg := DefaultCommandPoolConfig
+	cfg.Masters = []string{"master"}
+	cfg.Stdout = nil
+	cfg.Stderr = ioutil.Discard
+
+	cp := NewCommandPool(&cfg)
+	err = cp.AddJobs(
+		JobSpec{Name: "master", Commands: []string{"sleep 0.3"}},
+		JobSpec{Name: "db", Commands: []string{service("db")}, StopSignal: syscall.SIGTERM, StopPriority: 1},
+		JobSpec{Name: "app", Commands: []string{service("app")}, StopSignal: syscall.SIGTERM},
+		JobSpec{Name: "stubborn", Commands: []string{"sh -c 'trap \"\" TERM; while true; do sleep 0.05; done'"}, StopSignal: syscall.SIGTERM, StopGrace: 200 * time.Millisecond},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 but got %d", exitCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("stubborn job was not killed after the grace period")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(data) != "app\ndb\n" {
+		t.Errorf("unexpected order of stop: %q", data)
+	}
+}
+
+func TestTermSignal(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.TermSignal = syscall.SIGTERM
+	cfg.KillAfter = 5 * time.Second
+
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(
+		JobSpec{Commands: []string{"sh -c 'trap \"echo timed out; exit 0\" TERM; while true; do sleep 0.05; done'"}, Timeout: 200 * time.Millisecond},
+		JobSpec{Commands: []string{"sh -c 'trap \"echo terminated; exit 0\" TERM; while true; do sleep 0.05; done'"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	time.AfterFunc(400*time.Millisecond, cp.Terminate)
+
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != timeoutExitCode {
+		t.Errorf("expected exit code %d but got %d", timeoutExitCode, exitCode)
+	}
+	expected := "timed out\ncoshell: group #0 timed out after 200ms\nterminated\n"
+	if buf.String() != expected {
+		t.Errorf("expected output %q but got %q", expected, buf.String())
+	}
+}
+
+func TestStopHangingFinally(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := DefaultCommandPoolConfig
+	cfg.Deinterlace = true
+	cfg.Stdout = &buf
+	cfg.Stderr = &buf
+	cfg.Halt = true
+
+	// finally commands are killed after the grace period, and no other one starts
+	cp := NewCommandPool(&cfg)
+	err := cp.AddJobs(
+		JobSpec{Name: "builtin", Commands: []string{"sleep 10"}, Finally: []string{"sleep 10", "echo skipped"}, StopGrace: 200 * time.Millisecond},
+		JobSpec{Name: "process", Commands: []string{"sleep 10"}, Finally: []string{"sh -c 'exec sleep 10'", "echo skipped"}, StopGrace: 200 * time.Millisecond},
+		JobSpec{Commands: []string{"sleep 0.1", "exit 3"}},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = cp.Start(0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	exitCode, err := cp.Join()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exitCode != 3 {
+		t.Errorf("expected exit code 3 but got %d", exitCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("finally command was not killed after the grace period")
+	}
+	expected := "coshell: builtin did not stop within 200ms, killing it\ncoshell: process did not stop within 200ms, killing it\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
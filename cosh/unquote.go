@@ -14,25 +14,45 @@ var (
 	ErrUnterminatedDoubleQuote = errors.New("Unterminated double-quoted string")
 	// ErrUnterminatedEscape is returned when an escape sequence is unterminated.
 	ErrUnterminatedEscape = errors.New("Unterminated backslash-escape")
+	// ErrUnterminatedANSICQuote is returned when a $'...' string is unterminated.
+	ErrUnterminatedANSICQuote = errors.New("Unterminated $'...' string")
 )
 
+// ansiCEscapes are the single-character escapes of $'...' strings.
+var ansiCEscapes = map[byte]byte{
+	'a':  '\a',
+	'b':  '\b',
+	'e':  0x1b,
+	'E':  0x1b,
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+	'?':  '?',
+}
+
 var (
 	splitChars        = " \n\t"
 	singleChar        = '\''
 	doubleChar        = '"'
+	dollarChar        = '$'
 	escapeChar        = '\\'
 	doubleEscapeChars = "$`\"\n\\"
+	operatorChars     = "|&;<>"
 )
 
 // Split splits a string according to /bin/sh's word-splitting rules. It
-// supports backslash-escapes, single-quotes, and double-quotes. Notably it does
-// not support the $'' style of quoting. It also doesn't attempt to perform any
-// other sort of expansion, including brace expansion, shell expansion, or
-// pathname expansion.
+// supports backslash-escapes, single-quotes, double-quotes and the bash $'...'
+// style of quoting. It doesn't attempt to perform any other sort of
+// expansion, including brace expansion, shell expansion, or pathname expansion.
 //
 // If the given input has an unterminated quoted string or ends in a
 // backslash-escape, one of UnterminatedSingleQuoteError,
-// ErrUnterminatedDoubleQuote, or ErrUnterminatedEscape is returned.
+// ErrUnterminatedDoubleQuote, ErrUnterminatedANSICQuote or ErrUnterminatedEscape is returned.
 //
 // This function is from https://github.com/kballard/go-shellquote
 // under MIT license
@@ -61,7 +81,7 @@ func Split(input string) (words []string, err error) {
 		}
 
 		var word string
-		word, input, err = splitWord(input, &buf)
+		word, input, err = (&splitter{}).splitWord(input, &buf)
 		if err != nil {
 			return
 		}
@@ -70,8 +90,43 @@ func Split(input string) (words []string, err error) {
 	return
 }
 
-func splitWord(input string, buf *bytes.Buffer) (word string, remainder string, err error) {
+// splitter holds the options used to split words.
+type splitter struct {
+	// operators makes unquoted operator characters terminate words
+	operators bool
+	// keepSpaces makes unquoted split characters part of the word
+	keepSpaces bool
+	// parameters makes unquoted and double-quoted '$' introduce parameter expansions
+	parameters bool
+	// expansion is used to expand parameters and tildes; when nil they are validated
+	// and kept verbatim
+	expansion *expansion
+
+	// trackQuoting enables recording in quoted which bytes of the last split word were
+	// quoted, escaped or produced by expansions
+	trackQuoting bool
+	quoted       []bool
+}
+
+// write appends str to the word being split.
+func (s *splitter) write(buf *bytes.Buffer, str string, quoted bool) {
+	buf.WriteString(str)
+	if s.trackQuoting {
+		for i := 0; i < len(str); i++ {
+			s.quoted = append(s.quoted, quoted)
+		}
+	}
+}
+
+// splitWord splits the first word from input; the character terminating the word is
+// not consumed.
+func (s *splitter) splitWord(input string, buf *bytes.Buffer) (word string, remainder string, err error) {
 	buf.Reset()
+	s.quoted = s.quoted[:0]
+
+	if s.expansion != nil {
+		input = s.expansion.tilde(input, buf, s)
+	}
 
 raw:
 	{
@@ -81,24 +136,39 @@ raw:
 			cur = cur[l:]
 			switch {
 			case c == singleChar:
-				buf.WriteString(input[0 : len(input)-len(cur)-l])
+				s.write(buf, input[0:len(input)-len(cur)-l], false)
 				input = cur
 				goto single
 			case c == doubleChar:
-				buf.WriteString(input[0 : len(input)-len(cur)-l])
+				s.write(buf, input[0:len(input)-len(cur)-l], false)
 				input = cur
 				goto double
 			case c == escapeChar:
-				buf.WriteString(input[0 : len(input)-len(cur)-l])
+				s.write(buf, input[0:len(input)-len(cur)-l], false)
 				input = cur
 				goto escape
-			case strings.ContainsRune(splitChars, c):
-				buf.WriteString(input[0 : len(input)-len(cur)-l])
-				return buf.String(), cur, nil
+			case c == dollarChar && strings.HasPrefix(cur, string(singleChar)):
+				s.write(buf, input[0:len(input)-len(cur)-l], false)
+				input, err = s.ansiC(cur[1:], buf)
+				if err != nil {
+					return "", "", err
+				}
+				goto raw
+			case c == dollarChar && s.parameters:
+				s.write(buf, input[0:len(input)-len(cur)-l], false)
+				input, err = s.parameter(cur, buf)
+				if err != nil {
+					return "", "", err
+				}
+				goto raw
+			case strings.ContainsRune(splitChars, c) && !s.keepSpaces,
+				s.operators && strings.ContainsRune(operatorChars, c):
+				s.write(buf, input[0:len(input)-len(cur)-l], false)
+				return buf.String(), input[len(input)-len(cur)-l:], nil
 			}
 		}
 		if len(input) > 0 {
-			buf.WriteString(input)
+			s.write(buf, input, false)
 			input = ""
 		}
 		goto done
@@ -113,7 +183,7 @@ escape:
 		if c == '\n' {
 			// a backslash-escaped newline is elided from the output entirely
 		} else {
-			buf.WriteString(input[:l])
+			s.write(buf, input[:l], true)
 		}
 		input = input[l:]
 	}
@@ -125,7 +195,7 @@ single:
 		if i == -1 {
 			return "", "", ErrUnterminatedSingleQuote
 		}
-		buf.WriteString(input[0:i])
+		s.write(buf, input[0:i], true)
 		input = input[i+1:]
 		goto raw
 	}
@@ -137,19 +207,26 @@ double:
 			c, l := utf8.DecodeRuneInString(cur)
 			cur = cur[l:]
 			if c == doubleChar {
-				buf.WriteString(input[0 : len(input)-len(cur)-l])
+				s.write(buf, input[0:len(input)-len(cur)-l], true)
 				input = cur
 				goto raw
+			} else if c == dollarChar && s.parameters {
+				s.write(buf, input[0:len(input)-len(cur)-l], true)
+				input, err = s.parameter(cur, buf)
+				if err != nil {
+					return "", "", err
+				}
+				goto double
 			} else if c == escapeChar {
 				// bash only supports certain escapes in double-quoted strings
 				c2, l2 := utf8.DecodeRuneInString(cur)
 				cur = cur[l2:]
 				if strings.ContainsRune(doubleEscapeChars, c2) {
-					buf.WriteString(input[0 : len(input)-len(cur)-l-l2])
+					s.write(buf, input[0:len(input)-len(cur)-l-l2], true)
 					if c2 == '\n' {
 						// newline is special, skip the backslash entirely
 					} else {
-						buf.WriteRune(c2)
+						s.write(buf, string(c2), true)
 					}
 					input = cur
 				}
@@ -161,3 +238,117 @@ double:
 done:
 	return buf.String(), input, nil
 }
+
+// ansiC decodes a $'...' string, whose content follows in input, and returns the remainder
+// of input after the closing quote.
+func (s *splitter) ansiC(input string, buf *bytes.Buffer) (string, error) {
+	decoded, n, ok := decodeANSIC(input, true)
+	if !ok {
+		return "", ErrUnterminatedANSICQuote
+	}
+	s.write(buf, decoded, true)
+	return input[n:], nil
+}
+
+// decodeANSIC decodes the bash escapes of input and returns the decoded string and the number
+// of bytes consumed; when quoted is true, decoding stops after the first unescaped single quote
+// and false is returned if there is none. Like bash, the string is truncated at the first NUL character.
+func decodeANSIC(input string, quoted bool) (string, int, bool) {
+	var (
+		decoded   bytes.Buffer
+		truncated bool
+	)
+	writeByte := func(c byte) {
+		if c == 0 {
+			truncated = true
+		}
+		if !truncated {
+			decoded.WriteByte(c)
+		}
+	}
+
+	for i := 0; i < len(input); {
+		c := input[i]
+		i++
+		if quoted && c == byte(singleChar) {
+			return decoded.String(), i, true
+		}
+		if c != byte(escapeChar) {
+			writeByte(c)
+			continue
+		}
+		if i == len(input) {
+			if !quoted {
+				writeByte(c)
+			}
+			break
+		}
+
+		c = input[i]
+		i++
+		if e, ok := ansiCEscapes[c]; ok {
+			writeByte(e)
+			continue
+		}
+		switch c {
+		case 'c':
+			// control character
+			if i < len(input) {
+				writeByte(input[i] & 0x1f)
+				i++
+				continue
+			}
+		case 'x', 'u', 'U':
+			digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			n, l := parseDigits(input[i:], 16, digits)
+			if l == 0 {
+				break
+			}
+			i += l
+			if c == 'x' {
+				writeByte(byte(n))
+			} else if n == 0 {
+				writeByte(0)
+			} else if !truncated {
+				decoded.WriteRune(rune(n))
+			}
+			continue
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n, l := parseDigits(input[i-1:], 8, 3)
+			i += l - 1
+			writeByte(byte(n))
+			continue
+		}
+
+		// unknown escapes are kept verbatim
+		writeByte(byte(escapeChar))
+		writeByte(c)
+	}
+
+	return decoded.String(), len(input), !quoted
+}
+
+// parseDigits parses up to max digits in the specified base at the start of s and returns
+// the value and the number of digits parsed.
+func parseDigits(s string, base, max int) (int, int) {
+	n, l := 0, 0
+	for ; l < max && l < len(s); l++ {
+		var d int
+		c := s[l]
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c >= 'a' && c <= 'f':
+			d = int(c-'a') + 10
+		case c >= 'A' && c <= 'F':
+			d = int(c-'A') + 10
+		default:
+			return n, l
+		}
+		if d >= base {
+			break
+		}
+		n = n*base + d
+	}
+	return n, l
+}
@@ -0,0 +1,74 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+)
+
+// wrapperEnv is the environment variable which makes a program using this package run as
+// the wrapper of a command instead, in RunWrapperIfRequested, to set the priority and resource
+// limits of the command before executing it.
+const wrapperEnv = "COSHELL_WRAPPER"
+
+// wrapperSpec is the specification of the command executed by the wrapper.
+type wrapperSpec struct {
+	// Path is the command executed with the arguments of the wrapper
+	Path    string
+	Nice    int
+	IONice  IOPriority
+	Rlimits []Rlimit
+}
+
+// wrap makes the command run through the wrapper, i.e. the program itself, when the priority
+// or resource limits of its processes are set, so that they apply before the command runs.
+func (cg *CommandGroup) wrap(cmd *exec.Cmd) error {
+	spec := wrapperSpec{
+		Path:    cmd.Path,
+		Nice:    cg.nice,
+		IONice:  cg.ionice,
+		Rlimits: cg.rlimits,
+	}
+	if spec.Nice == 0 && spec.IONice.Class == IONone && len(spec.Rlimits) == 0 {
+		return nil
+	}
+	if cmd.Err != nil {
+		// reported by Start
+		return nil
+	}
+	self, err := wrapperPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Path = self
+	cmd.Env = append(env[:len(env):len(env)], wrapperEnv+"="+string(data))
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// RunWrapperIfRequested runs the program as the wrapper of a command, setting its priority and
+// resource limits, and never returns when the program was started as such by a command pool;
+// otherwise it does nothing. Programs using priorities or resource limits of command pools must
+// call it first thing in main, before any other initialization.
+func RunWrapperIfRequested() {
+	data, ok := os.LookupEnv(wrapperEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(wrapperEnv)
+	os.Exit(runWrapper(data))
+}
+
+// wrapperPath returns the path of the wrapper, i.e. of the program itself.
+func wrapperPath() (string, error) {
+	return "/proc/self/exe", nil
+}
+
+// runWrapper sets the priority and resource limits specified by the wrapper specification and executes its command,
+// with the same arguments as the wrapper; it only returns the exit code of failures.
+func runWrapper(data string) int {
+	var spec wrapperSpec
+	err := json.Unmarshal([]byte(data), &spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "coshell: invalid %s: %v\n", wrapperEnv, err)
+		return 126
+	}
+
+	err = setRlimits(spec.Rlimits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "coshell: cannot set resource limits: %v\n", err)
+		return 126
+	}
+
+	// the priorities of threads are kept by the one executing the command
+	runtime.LockOSThread()
+	err = setPriority(spec.Nice, spec.IONice)
+	if err != nil {
+		// like nice(1), the command runs anyway
+		fmt.Fprintf(os.Stderr, "coshell: cannot set priority: %v\n", err)
+	}
+
+	err = syscall.Exec(spec.Path, os.Args, os.Environ())
+	fmt.Fprintf(os.Stderr, "coshell: %s: %v\n", spec.Path, err)
+	if err == syscall.ENOENT {
+		return 127
+	}
+	return 126
+}
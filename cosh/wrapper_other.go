@@ -0,0 +1,36 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package cosh
+
+import "errors"
+
+// errWrapperUnsupported is returned when priorities or resource limits are set on platforms other than Linux.
+var errWrapperUnsupported = errors.New("priorities and resource limits are only supported on Linux")
+
+func wrapperPath() (string, error) {
+	return "", errWrapperUnsupported
+}
+
+// RunWrapperIfRequested does nothing, as commands are never wrapped on platforms other than Linux.
+func RunWrapperIfRequested() {
+}
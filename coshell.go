@@ -20,12 +20,14 @@ Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
 package main
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gdm85/coshell/cosh"
 
@@ -38,21 +40,87 @@ func fatal(err error) {
 }
 
 func main() {
+	// when running as the wrapper of a command, to set its priority and resource limits
+	cosh.RunWrapperIfRequested()
+
 	var (
-		version        bool
-		cfg            = cosh.DefaultCommandPoolConfig
-		jobs           int
-		sequenceLength int
-		shellArgs      string
+		version    bool
+		dryRun     bool
+		cfg        = cosh.DefaultCommandPoolConfig
+		jobs       string
+		inputCfg   = cosh.DefaultInputConfig
+		shellArgs  string
+		stdinMode  string
+		pipe       bool
+		pipePart   bool
+		argFile    string
+		pipeCfg    = cosh.DefaultPipeConfig
+		blockSize  string
+		groupMode  string
+		manifest   string
+		tags       string
+		masters    string
+		policy     string
+		masterExit string
+		restart    string
+		termSignal string
+		jobsFile   string
+		memFree    string
+		nice       string
+		ionice     string
+		ulimit     string
+		memoryMax  string
+		cpuMax     string
+		pidsMax    string
 	)
 
 	flag.BoolVarP(&version, "version", "v", false, "Display version and exit")
+	flag.BoolVarP(&dryRun, "dry-run", "n", false, "Print the commands which would be run, one group per line, and exit")
 	flag.BoolVarP(&cfg.Deinterlace, "deinterlace", "d", false, "Show individual output of processes in blocks, second order of termination")
 	flag.BoolVarP(&cfg.Halt, "halt-all", "a", false, "Terminate neighbour processes as soon as any has failed, using its exit code")
-	flag.IntVarP(&cfg.MasterID, "master", "m", -1, "Terminate neighbour processes as soon as command from specified input line exits and use its exit code; multiplied by sequence-length")
-	flag.IntVarP(&sequenceLength, "sequence-length", "l", 1, "Execute this amount of lines in sequence; corresponds to '&&' shell command concatenation.")
-	flag.IntVarP(&jobs, "jobs", "j", 8, "Use specified number of jobs; specify 0 for unlimited concurrency")
+	flag.StringVarP(&masters, "master", "m", "", "Terminate neighbour processes as soon as the specified job exits and use its exit code; jobs are specified by name or by index of their sequence, comma-separated")
+	flag.IntVarP(&inputCfg.SequenceLength, "sequence-length", "l", 1, "Execute this amount of lines in sequence; corresponds to '&&' shell command concatenation.")
+	flag.BoolVar(&inputCfg.Paragraphs, "paragraphs", false, "Execute in sequence each block of consecutive lines, separated by blank lines; cannot be used with --sequence-length")
+	flag.StringVar(&inputCfg.GroupSeparator, "group-separator", "", "Execute in sequence the lines between each line equal to the specified separator; cannot be used with --sequence-length")
+	flag.BoolVar(&inputCfg.Labels, "labels", false, "Execute in sequence all lines starting with the same 'label:' prefix")
+	flag.StringVar(&groupMode, "group-mode", "&&", "Mode of sequences: '&&' (or 'and') stops at the first failure, ';' (or 'all') runs all commands and uses the worst exit code, '||' (or 'or') stops at the first success")
+	flag.StringVar(&inputCfg.Finally, "finally", "", "Command always executed after each sequence, even if it failed or was terminated")
+	flag.StringVarP(&jobs, "jobs", "j", "8", "Use specified number of jobs; specify 0 for unlimited concurrency, a percentage of the available CPUs like 200% or a number of CPUs to add or subtract like +2 or -1")
+	flag.StringVar(&jobsFile, "jobs-file", "", "Read the number of jobs from the specified file, re-read every second; the number of jobs can also be incremented with SIGUSR1 and decremented with SIGUSR2")
+	flag.Float64Var(&cfg.MaxLoad, "load", 0, "Do not start jobs while the load average of the last minute is at least the specified value")
+	flag.StringVar(&memFree, "memfree", "", "Do not start jobs while less than the specified memory is available, e.g. 2G")
+	flag.DurationVar(&cfg.StartDelay, "delay", 0, "Wait at least the specified duration between the start of any two jobs")
+	flag.StringVar(&nice, "nice", "", "Run processes with the specified niceness, from -20 to 19, unless specified by their job")
+	flag.StringVar(&ionice, "ionice", "", "Run processes with the specified I/O priority, e.g. idle or best-effort:7, unless specified by their job (Linux only)")
+	flag.StringVar(&ulimit, "ulimit", "", "Limit resources of processes, e.g. nofile=1024,as=4G,core=0 or cpu=60:120 for soft and hard limits; resources are as, nofile, cpu, core and nproc (Linux only)")
+	flag.StringVar(&policy, "master-policy", "any", "Terminate neighbour processes when 'any' or 'all' of the masters have exited")
+	flag.StringVar(&masterExit, "master-exit", "first", "Use the exit code of the 'first' or 'last' master which exited, or the 'worst' one")
 	flag.StringVarP(&shellArgs, "shell", "s", "sh -c", "If specified, the specified space-separated arguments will be used as shell prefix and the whole line will be passed as a single argument")
+	flag.BoolVarP(&cfg.Expand, "expand", "e", false, "Expand environment variables ($VAR, ${VAR:-default}) and tildes in shell-less mode; expanded values are never split in further words")
+	flag.BoolVarP(&cfg.NoUnset, "nounset", "u", false, "Treat expansion of unset variables as an error in shell-less mode")
+	flag.BoolVarP(&cfg.Glob, "glob", "g", false, "Expand braces ({a,b} and {1..3}) and unquoted pathname patterns (*, ? and [...]) in shell-less mode")
+	flag.StringVar(&manifest, "manifest", "", "Read jobs from the specified manifest file, in JSON or line-oriented format, instead of standard input")
+	flag.StringVar(&tags, "tags", "", "Run only the manifest jobs with any of the specified comma-separated tags or names, and the jobs they depend on")
+	flag.StringVar(&restart, "restart", "never", "Restart jobs when they exit: 'never', 'on-failure' or 'always'; jobs are never restarted once terminated")
+	flag.IntVar(&cfg.Restart.MaxRestarts, "max-restarts", 0, "Stop restarting a job after the specified number of restarts within --restart-window; specify 0 for unlimited restarts")
+	flag.DurationVar(&cfg.Restart.Window, "restart-window", 0, "Period in which restarts are counted for --max-restarts; specify 0 to count all restarts")
+	flag.DurationVar(&cfg.Restart.Backoff, "restart-backoff", time.Second, "Delay before restarting a job, doubled after each consecutive failure up to 1m")
+	flag.StringVar(&termSignal, "term-signal", "KILL", "Signal sent to processes when jobs are terminated, e.g. by --halt-all, --master, timeouts or when coshell receives SIGTERM or SIGINT")
+	flag.DurationVar(&cfg.KillAfter, "kill-after", 10*time.Second, "Kill processes which did not exit within the specified duration after --term-signal")
+	flag.BoolVar(&cfg.ProcessGroups, "process-groups", false, "Start each process in its own process group, so that its descendants are terminated and waited for as well")
+	flag.BoolVar(&cfg.Cgroups, "cgroups", false, "Run each job in its own cgroup v2, so that all its descendants are killed, and report its peak memory usage; requires a delegated cgroup (Linux only)")
+	flag.StringVar(&cfg.CgroupParent, "cgroup-parent", "", "Create the cgroups of jobs within the specified cgroup directory instead of the cgroup of coshell")
+	flag.StringVar(&memoryMax, "memory-max", "", "Limit the memory of each job with its cgroup, e.g. 2G; implies --cgroups")
+	flag.StringVar(&cpuMax, "cpu-max", "", "Limit the CPU usage of each job with its cgroup to the specified number of CPUs, e.g. 1.5; implies --cgroups")
+	flag.StringVar(&pidsMax, "pids-max", "", "Limit the number of processes of each job with its cgroup; implies --cgroups")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for processes to exit once jobs are terminated; processes still running are then killed and reported")
+	flag.StringVar(&stdinMode, "stdin", "none", "Standard input of commands: none, null, tty, file:PATH or inherit-first, only with --manifest")
+	flag.BoolVar(&pipe, "pipe", false, "Split standard input in chunks and feed each chunk to a separate job running the command specified as arguments")
+	flag.BoolVar(&pipePart, "pipepart", false, "Split the file specified with --arg-file in sections and feed each section to a separate job running the command specified as arguments")
+	flag.StringVar(&argFile, "arg-file", "", "Regular file to be split in --pipepart mode")
+	flag.StringVar(&blockSize, "block", "1M", "Size of each chunk in --pipe and --pipepart modes; chunks always end at a record boundary")
+	flag.IntVar(&pipeCfg.Records, "records", 0, "Number of records in each chunk in --pipe mode; overrides --block")
+	flag.StringVar(&pipeCfg.RecordEnd, "recend", "\n", "Record delimiter in --pipe and --pipepart modes")
 
 	showVersion := func() {
 		fmt.Fprintf(os.Stderr, "coshell v0.2.5 by gdm85 - Licensed under GNU GPLv2\n")
@@ -61,67 +129,265 @@ func main() {
 	flag.Usage = func() {
 		showVersion()
 		fmt.Fprintf(os.Stderr, "Usage:\n\tcoshell [--jobs=8|-j8] [--deinterlace|-d] [--halt-all|-a] < list-of-commands\n")
+		fmt.Fprintf(os.Stderr, "\tcoshell --pipe [--block=1M|--records=N] [--jobs=8|-j8] command [arguments...] < input\n")
+		fmt.Fprintf(os.Stderr, "\tcoshell --pipepart --arg-file=FILE [--block=1M] [--jobs=8|-j8] command [arguments...]\n")
 		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "Each line read from standard input will be run as a command via `sh -c` (can be overriden with --shell=); empty lines are ignored\n")
+		fmt.Fprintf(os.Stderr, "Each line read from standard input will be run as a command via `sh -c` (can be overriden with --shell=); empty lines and lines starting with '#' are ignored,\n")
+		fmt.Fprintf(os.Stderr, "lines ending with a backslash continue on the next line and lines between '{' and '}' lines or joined by a trailing '&&' are executed in sequence\n")
 	}
 
 	flag.Parse()
 
-	if len(flag.Args()) != 0 {
-		fmt.Fprintf(os.Stderr, "Invalid arguments specified\n")
-		os.Exit(1)
-	}
-
 	if version {
 		showVersion()
 		os.Exit(0)
 	}
 
-	// collect all commands to run from stdin
-	var commandLines []string
+	if pipe && pipePart {
+		fatal(errors.New("--pipe and --pipepart cannot be used together"))
+		return
+	}
+	if pipePart {
+		if argFile == "" {
+			fatal(errors.New("a file must be specified with --arg-file in --pipepart mode"))
+			return
+		}
+		if pipeCfg.Records != 0 {
+			fatal(errors.New("--records cannot be used in --pipepart mode"))
+			return
+		}
+	} else if argFile != "" {
+		fatal(errors.New("--arg-file can only be used in --pipepart mode"))
+		return
+	}
 
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		line, err := reader.ReadString('\n')
+	if manifest != "" && (pipe || pipePart) {
+		fatal(errors.New("--manifest cannot be used in --pipe and --pipepart modes"))
+		return
+	}
+	if tags != "" && manifest == "" {
+		fatal(errors.New("--tags can only be used with --manifest"))
+		return
+	}
 
+	// in pipe modes the input is data and the command is specified as arguments
+	pipe = pipe || pipePart
+	if pipe {
+		if len(flag.Args()) == 0 {
+			fatal(errors.New("a command must be specified as arguments in --pipe and --pipepart modes"))
+			return
+		}
+		if stdinMode != "none" {
+			fatal(errors.New("--stdin cannot be used in --pipe and --pipepart modes"))
+			return
+		}
+	} else if len(flag.Args()) != 0 {
+		fmt.Fprintf(os.Stderr, "Invalid arguments specified\n")
+		os.Exit(1)
+	}
+
+	var err error
+	cfg.Stdin, cfg.StdinPath, err = cosh.ParseStdinMode(stdinMode)
+	if err != nil {
+		fatal(err)
+		return
+	}
+	if cfg.Stdin == cosh.StdinInheritFirst && manifest == "" {
+		fatal(errors.New("--stdin=inherit-first can only be used with --manifest, as commands are read from standard input"))
+		return
+	}
+
+	inputCfg.Mode, err = cosh.ParseGroupMode(groupMode)
+	if err != nil {
+		fatal(err)
+		return
+	}
+	if manifest != "" && inputCfg != cosh.DefaultInputConfig {
+		fatal(errors.New("sequence length and grouping options cannot be used with --manifest"))
+		return
+	}
+	if (inputCfg.Paragraphs || inputCfg.GroupSeparator != "") && inputCfg.SequenceLength != 1 {
+		fatal(errors.New("--paragraphs and --group-separator cannot be used with --sequence-length"))
+		return
+	}
+
+	var (
+		jobSpecs []cosh.JobSpec
+		chunks   [][]byte
+		stream   *cosh.ChunkReader
+		sections []cosh.Section
+		pipeJobs int
+	)
+	if pipe {
+		pipeCfg.BlockSize, err = cosh.ParseSize(blockSize)
+		if err != nil {
+			fatal(err)
+			return
+		}
+		pipeCfg.RecordEnd = strings.Replace(pipeCfg.RecordEnd, "\\n", "\n", -1)
+
+		if pipePart {
+			// the file is read directly by each job, only record boundaries are looked up here
+			sections, err = cosh.FileSections(argFile, pipeCfg)
+			pipeJobs = len(sections)
+		} else if dryRun {
+			// standard input is data, all chunks are needed to list their jobs
+			chunks, err = cosh.ReadChunks(os.Stdin, pipeCfg)
+			pipeJobs = len(chunks)
+		} else {
+			// standard input is data, split in chunks for each job as it is read
+			stream, err = cosh.NewChunkReader(os.Stdin, pipeCfg)
+			pipeJobs = -1
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
+			fatal(err)
+			return
+		}
+		if pipeJobs == 0 {
+			// nothing to do
+			os.Exit(0)
+		}
+	} else {
+		if inputCfg.SequenceLength < 1 {
+			fatal(errors.New("sequence length must be at least 1"))
+			return
+		}
+		if manifest != "" {
+			jobSpecs, err = readManifest(manifest, tags)
+		} else {
+			var groups []cosh.InputGroup
+			groups, err = cosh.ReadGroups(os.Stdin, inputCfg)
+			for _, g := range groups {
+				jobSpecs = append(jobSpecs, g.JobSpec())
 			}
-
-			// crash in case of other errors
+		}
+		if err != nil {
 			fatal(err)
 			return
 		}
+	}
 
-		line = strings.TrimSuffix(line, "\n")
-
-		if len(line) == 0 {
-			continue
+	if pipe {
+		if inputCfg != cosh.DefaultInputConfig {
+			fatal(errors.New("sequence length and grouping options cannot be used in --pipe mode"))
+			return
+		}
+	} else {
+		if len(jobSpecs) == 0 {
+			fatal(errors.New("please specify at least 1 command in standard input"))
+			return
 		}
+	}
 
-		commandLines = append(commandLines, line)
+	n, err := cosh.ParseJobs(jobs)
+	if err != nil {
+		fatal(err)
+		return
 	}
 
-	if sequenceLength < 1 {
-		fatal(errors.New("sequence length must be at least 1"))
+	if masters != "" {
+		for _, m := range strings.Split(masters, ",") {
+			cfg.Masters = append(cfg.Masters, strings.TrimSpace(m))
+		}
+	}
+	cfg.MasterPolicy, err = cosh.ParseMasterPolicy(policy)
+	if err != nil {
+		fatal(err)
+		return
+	}
+	cfg.MasterExit, err = cosh.ParseMasterExit(masterExit)
+	if err != nil {
+		fatal(err)
+		return
 	}
 
-	if len(commandLines) == 0 {
-		fatal(errors.New("please specify at least 1 command in standard input"))
+	cfg.Restart.Policy, err = cosh.ParseRestartPolicy(restart)
+	if err != nil {
+		fatal(err)
 		return
 	}
-	if cfg.MasterID != -1 && cfg.MasterID >= len(commandLines)/sequenceLength {
-		fatal(errors.New("specified master command index is beyond last specified command"))
+	if cfg.Restart.MaxRestarts < 0 {
+		fatal(errors.New("invalid number of restarts"))
 		return
 	}
 
-	if len(commandLines)%sequenceLength != 0 {
-		fatal(errors.New("specified commands must be a multiple of sequence length"))
+	cfg.TermSignal, err = cosh.ParseSignal(termSignal)
+	if err != nil {
+		fatal(err)
+		return
+	}
+	if cfg.KillAfter <= 0 {
+		fatal(errors.New("--kill-after must be positive"))
+		return
 	}
 
-	if jobs < 0 {
-		fatal(errors.New("invalid jobs number"))
+	if cfg.MaxLoad < 0 {
+		fatal(errors.New("invalid --load value"))
+		return
+	}
+	if memFree != "" {
+		cfg.MinMemFree, err = cosh.ParseSize(memFree)
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+	if cfg.StartDelay < 0 {
+		fatal(errors.New("invalid --delay value"))
+		return
+	}
+	if nice != "" {
+		cfg.Nice, err = cosh.ParseNice(nice)
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+	if ionice != "" {
+		cfg.IONice, err = cosh.ParseIOPriority(ionice)
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+	if ulimit != "" {
+		cfg.Rlimits, err = cosh.ParseRlimits(ulimit)
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+	if memoryMax != "" {
+		cfg.CgroupLimits.MemoryMax, err = cosh.ParseSize(memoryMax)
+		if err == nil && cfg.CgroupLimits.MemoryMax == 0 {
+			err = errors.New("invalid --memory-max value")
+		}
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+	if cpuMax != "" {
+		cfg.CgroupLimits.CPUMax, err = cosh.ParseCPUMax(cpuMax)
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+	if pidsMax != "" {
+		cfg.CgroupLimits.PidsMax, err = cosh.ParsePidsMax(pidsMax)
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		fatal(errors.New("--shutdown-timeout must be positive"))
+		return
+	}
+	if cfg.ProcessGroups && cfg.Stdin == cosh.StdinTTY {
+		fatal(errors.New("--stdin=tty cannot be used with --process-groups, as processes cannot read from the terminal"))
 		return
 	}
 
@@ -129,25 +395,163 @@ func main() {
 		cfg.ShellArgs = strings.Split(shellArgs, " ")
 	}
 
+	if (cfg.Expand || cfg.NoUnset || cfg.Glob) && len(cfg.ShellArgs) != 0 {
+		fatal(errors.New("expansion options can only be used in shell-less mode"))
+		return
+	}
+	if cfg.NoUnset && !cfg.Expand {
+		fatal(errors.New("--nounset requires --expand"))
+		return
+	}
+
 	cg := cosh.NewCommandPool(&cfg)
 
-	err := cg.Add(sequenceLength, commandLines...)
+	if pipePart {
+		err = cg.AddPipePart(pipeCommandLine(cg, flag.Args()), argFile, sections)
+	} else if stream != nil {
+		err = cg.AddPipeStream(pipeCommandLine(cg, flag.Args()), stream)
+	} else if pipe {
+		err = cg.AddPipe(pipeCommandLine(cg, flag.Args()), chunks)
+	} else {
+		err = cg.AddJobs(jobSpecs...)
+	}
 	if err != nil {
 		fatal(err)
 		return
 	}
 
-	err = cg.Start(jobs)
+	if dryRun {
+		err = cg.DryRun(os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	// jobs are stopped when coshell is signalled, and killed when signalled again
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	signalled := make(chan os.Signal, 1)
+	go func() {
+		signalled <- <-signals
+		go cg.Terminate()
+		<-signals
+		cg.Kill()
+	}()
+
+	if jobsFile != "" {
+		// the file is not required to exist when starting
+		if m, err := readJobsFile(jobsFile); err == nil {
+			n = m
+		}
+	}
+
+	if cfg.ProcessGroups {
+		// descendants left behind are waited for as well
+		err = cosh.SetSubreaper()
+		if err != nil {
+			fatal(err)
+			return
+		}
+	}
+
+	err = cg.Start(n)
 	if err != nil {
 		fatal(err)
 		return
 	}
 
+	// the number of jobs can be changed while running
+	adjustJobsOnSignals(cg)
+	if jobsFile != "" {
+		go watchJobsFile(cg, jobsFile)
+	}
+
 	exitCode, err := cg.Join()
 	if err != nil {
 		fatal(err)
 		return
 	}
+	for _, result := range cg.Results() {
+		if result.PeakMemory != 0 {
+			name := result.Name
+			if name == "" {
+				name = fmt.Sprintf("group #%d", result.Index)
+			}
+			fmt.Fprintf(os.Stderr, "coshell: %s used at most %s of memory\n", name, cosh.FormatSize(result.PeakMemory))
+		}
+	}
 
+	select {
+	case sig := <-signalled:
+		// like shells, exit with the number of the signal which terminated coshell
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	default:
+	}
 	os.Exit(exitCode)
 }
+
+// readJobsFile reads the number of jobs from a --jobs-file.
+func readJobsFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := cosh.ParseJobs(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return n, nil
+}
+
+// watchJobsFile re-reads the --jobs-file every second, changing the number of jobs whenever
+// the file changes; errors are reported once until the file changes again.
+func watchJobsFile(cg *cosh.CommandPool, path string) {
+	// already read when starting
+	n, err := readJobsFile(path)
+	last := fmt.Sprint(n, err)
+	for range time.Tick(time.Second) {
+		n, err := readJobsFile(path)
+		s := fmt.Sprint(n, err)
+		if s == last {
+			continue
+		}
+		last = s
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "coshell: %v\n", err)
+			}
+			continue
+		}
+		cg.SetJobs(n)
+		fmt.Fprintf(os.Stderr, "coshell: number of jobs set to %d\n", cg.Jobs())
+	}
+}
+
+// readManifest reads the jobs of a manifest file, keeping only those with any of the
+// comma-separated tags or names, if specified.
+func readManifest(path, tags string) ([]cosh.JobSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	jobSpecs, err := cosh.ReadManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if tags != "" {
+		jobSpecs = cosh.FilterJobs(jobSpecs, strings.Split(tags, ","))
+	}
+	return jobSpecs, nil
+}
+
+// pipeCommandLine returns the command line to run in --pipe modes; a single argument
+// is used as-is, while multiple arguments are quoted as needed.
+func pipeCommandLine(cg *cosh.CommandPool, args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return cg.Quoter()(args)
+}
@@ -1,5 +1,5 @@
 module github.com/gdm85/coshell
 
-go 1.12
+go 1.20
 
 require github.com/ogier/pflag v0.0.1
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * coshell v0.2.5 - a no-frills dependency-free replacement for GNU parallel
+ * Copyright (C) 2014-2020 gdm85 - https://github.com/gdm85/coshell/
+
+This program is free software; you can redistribute it and/or
+modify it under the terms of the GNU General Public License
+as published by the Free Software Foundation; either version 2
+of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program; if not, write to the Free Software
+Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gdm85/coshell/cosh"
+)
+
+// adjustJobsOnSignals increments the number of jobs on SIGUSR1 and decrements it on SIGUSR2.
+func adjustJobsOnSignals(cg *cosh.CommandPool) {
+	adjust := make(chan os.Signal, 1)
+	signal.Notify(adjust, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range adjust {
+			n := cg.Jobs() + 1
+			if sig == syscall.SIGUSR2 {
+				n = cg.Jobs() - 1
+			}
+			if n >= 1 {
+				cg.SetJobs(n)
+				fmt.Fprintf(os.Stderr, "coshell: number of jobs set to %d\n", n)
+			}
+		}
+	}()
+}